@@ -0,0 +1,201 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// Arithmetic on scalar values
+// Will calculate the scalarExpr supporting the following operands: '+', '-', '*', '/', '%', '**'
+// Two integer values gives an integer result, otherwise both values are promoted to float64.
+// Like integer literals, integer results are int64, or uint64 when too large for an int64.
+// Integer results not fitting in 64 bits returns an error rather than wrapping around
+func arithmetic(env *Environment, operand string, l *ScalarExpr, r *ScalarExpr) (Expression, error) {
+	vl, lok := toInt64(l.Value())
+	vr, rok := toInt64(r.Value())
+	if lok && rok {
+		switch operand {
+		case "+":
+			if (vr > 0 && vl > math.MaxInt64-vr) || (vr < 0 && vl < math.MinInt64-vr) {
+				return wideArithmetic(env, operand, vl, vr)
+			}
+			return NewScalarExprV(vl + vr), nil
+		case "-":
+			if (vr < 0 && vl > math.MaxInt64+vr) || (vr > 0 && vl < math.MinInt64+vr) {
+				return wideArithmetic(env, operand, vl, vr)
+			}
+			return NewScalarExprV(vl - vr), nil
+		case "*":
+			res, ok := mulInt64(vl, vr)
+			if !ok {
+				return wideArithmetic(env, operand, vl, vr)
+			}
+			return NewScalarExprV(res), nil
+		case "/":
+			if vr == 0 {
+				return env.Null(), errors.New("integer division by zero")
+			}
+			if vl == math.MinInt64 && vr == -1 {
+				return wideArithmetic(env, operand, vl, vr)
+			}
+			return NewScalarExprV(vl / vr), nil
+		case "%":
+			if vr == 0 {
+				return env.Null(), errors.New("integer division by zero")
+			}
+			return NewScalarExprV(vl % vr), nil
+		case "**":
+			if vr < 0 {
+				return NewScalarExprV(math.Pow(float64(vl), float64(vr))), nil
+			}
+			res, ok := ipow(vl, vr)
+			if !ok {
+				return wideArithmetic(env, operand, vl, vr)
+			}
+			return NewScalarExprV(res), nil
+		default:
+			return env.Null(), fmt.Errorf("operand not supported: %s", operand)
+		}
+	}
+	if _, kl := promote(l.Value()); kl == signedNum || kl == unsignedNum {
+		if _, kr := promote(r.Value()); kr == signedNum || kr == unsignedNum {
+			// uint64 values larger than math.MaxInt64
+			return wideArithmetic(env, operand, l.Value(), r.Value())
+		}
+	}
+	fl, lok := toFloat64(l.Value())
+	fr, rok := toFloat64(r.Value())
+	if !lok || !rok {
+		return env.Null(), fmt.Errorf("arithmetic not supported on: %T %s %T", l.Value(), operand, r.Value())
+	}
+	switch operand {
+	case "+":
+		return NewScalarExprV(fl + fr), nil
+	case "-":
+		return NewScalarExprV(fl - fr), nil
+	case "*":
+		return NewScalarExprV(fl * fr), nil
+	case "/":
+		return NewScalarExprV(fl / fr), nil
+	case "%":
+		return NewScalarExprV(math.Mod(fl, fr)), nil
+	case "**":
+		return NewScalarExprV(math.Pow(fl, fr)), nil
+	default:
+		return env.Null(), fmt.Errorf("operand not supported: %s", operand)
+	}
+}
+
+// wideArithmetic calculates integers of different kinds, and integer results not fitting in an int64.
+// The result is an int64 or an uint64 like integer literals, larger results returns an error
+func wideArithmetic(env *Environment, operand string, l interface{}, r interface{}) (Expression, error) {
+	bl, br := toBigInt(l), toBigInt(r)
+	res := new(big.Int)
+	switch operand {
+	case "+":
+		res.Add(bl, br)
+	case "-":
+		res.Sub(bl, br)
+	case "*":
+		res.Mul(bl, br)
+	case "/", "%":
+		if br.Sign() == 0 {
+			return env.Null(), errors.New("integer division by zero")
+		}
+		if operand == "/" {
+			res.Quo(bl, br)
+		} else {
+			res.Rem(bl, br)
+		}
+	case "**":
+		if br.Sign() < 0 {
+			fl, _ := toFloat64(l)
+			fr, _ := toFloat64(r)
+			return NewScalarExprV(math.Pow(fl, fr)), nil
+		}
+		// Bases other than -1, 0 and 1 overflows before the 64th power
+		if bl.CmpAbs(big.NewInt(1)) > 0 && br.Cmp(big.NewInt(64)) > 0 {
+			return env.Null(), overflow(l, operand, r)
+		}
+		res.Exp(bl, br, nil)
+	default:
+		return env.Null(), fmt.Errorf("operand not supported: %s", operand)
+	}
+	if res.IsInt64() {
+		return NewScalarExprV(res.Int64()), nil
+	}
+	if res.IsUint64() {
+		return NewScalarExprV(res.Uint64()), nil
+	}
+	return env.Null(), overflow(l, operand, r)
+}
+
+// toBigInt returns the integer value as a big.Int
+func toBigInt(v interface{}) *big.Int {
+	p, k := promote(v)
+	if k == unsignedNum {
+		return new(big.Int).SetUint64(p.(uint64))
+	}
+	i, _ := toInt64(p)
+	return big.NewInt(i)
+}
+
+// negate returns the numeric negation of a scalar value
+func negate(env *Environment, s *ScalarExpr) (Expression, error) {
+	if v, ok := toInt64(s.Value()); ok {
+		if v == math.MinInt64 {
+			return NewScalarExprV(uint64(-math.MinInt64)), nil
+		}
+		return NewScalarExprV(-v), nil
+	}
+	if v, k := promote(s.Value()); k == unsignedNum {
+		// The literal -9223372036854775808 is the negation of an uint64
+		if v.(uint64) == -math.MinInt64 {
+			return NewScalarExprV(int64(math.MinInt64)), nil
+		}
+		return env.Null(), fmt.Errorf("integer overflow: -%d", v)
+	}
+	if v, ok := toFloat64(s.Value()); ok {
+		return NewScalarExprV(-v), nil
+	}
+	return env.Null(), fmt.Errorf("negation not supported on: %T", s.Value())
+}
+
+// overflow returns the error of an integer operation with a result not fitting in 64 bits
+func overflow(l interface{}, operand string, r interface{}) error {
+	return fmt.Errorf("integer overflow: %v %s %v", l, operand, r)
+}
+
+// mulInt64 returns a*b, ok is false if the product overflows an int64
+func mulInt64(a int64, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	res := a * b
+	if res/b != a || (a == math.MinInt64 && b == -1) {
+		return 0, false
+	}
+	return res, true
+}
+
+// ipow calculates base**exp for a non negative exponent by repeated squaring, ok is false if the result overflows an int64
+func ipow(base int64, exp int64) (int64, bool) {
+	result := int64(1)
+	ok := true
+	for exp > 0 {
+		if exp&1 == 1 {
+			if result, ok = mulInt64(result, base); !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp > 0 {
+			if base, ok = mulInt64(base, base); !ok {
+				return 0, false
+			}
+		}
+	}
+	return result, true
+}
@@ -0,0 +1,174 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Bind registers a go value in the environment with the provided name.
+// Structs and maps with string keys are exposed to scripts as an ObjectExpr, where members are
+// resolved by reflection when they are accessed during evaluation, on the form name.field or name['key'].
+// Bind a pointer to a struct to have scripts see changes made after the value was bound.
+// Other values are converted when bound, see NewObjectExpr.
+func (e *Environment) Bind(name string, value interface{}) error {
+	return e.Set(name, wrapValue(reflect.ValueOf(value)))
+}
+
+// wrapValue converts a go value to an expression:
+//   - nil pointers, interfaces, maps and slices are converted to a null scalar
+//   - booleans, numbers and strings are converted to scalars, numbers beeing converted to int64, uint64 or float64
+//   - structs and maps with string keys are wrapped in an ObjectExpr
+//   - slices and arrays are converted to a ListExpr with every element wrapped
+//   - expressions are returned as is
+//   - other values are wrapped in a scalar
+func wrapValue(v reflect.Value) Expression {
+	if !v.IsValid() {
+		return NewScalarExprV(nil)
+	}
+	if v.CanInterface() {
+		if ex, ok := v.Interface().(Expression); ok {
+			return ex
+		}
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return NewScalarExprV(nil)
+		}
+		return wrapValue(v.Elem())
+	case reflect.Bool:
+		return NewScalarExprV(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewScalarExprV(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return NewScalarExprV(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return NewScalarExprV(v.Float())
+	case reflect.String:
+		return NewScalarExprV(v.String())
+	case reflect.Struct:
+		return &ObjectExpr{value: v}
+	case reflect.Map:
+		if v.IsNil() {
+			return NewScalarExprV(nil)
+		}
+		if v.Type().Key().Kind() == reflect.String {
+			return &ObjectExpr{value: v}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NewScalarExprV(nil)
+		}
+		list := NewListExpr()
+		for i := 0; i < v.Len(); i++ {
+			list.Append(wrapValue(v.Index(i)))
+		}
+		return list
+	}
+	if v.CanInterface() {
+		return NewScalarExprV(v.Interface())
+	}
+	return NewScalarExprV(nil)
+}
+
+//=============================================================================
+
+// ObjectExpr is a expression exposing a go struct or a map with string keys to scripts.
+// Exported struct fields are accessed by their go name or by their json tag name.
+// Fields of embedded structs are promoted as in go.
+type ObjectExpr struct {
+	value reflect.Value
+}
+
+// NewObjectExpr wraps a go value in an expression, see Environment.Bind.
+// Structs and maps with string keys gives an ObjectExpr, other values are converted to their expression counterpart
+func NewObjectExpr(value interface{}) Expression {
+	return wrapValue(reflect.ValueOf(value))
+}
+
+// Member for implementation of accessor interface.
+// The member is resolved from the go value each time it is accessed
+func (e *ObjectExpr) Member(key string) (Expression, bool) {
+	switch e.value.Kind() {
+	case reflect.Struct:
+		idx, ok := structFields(e.value.Type())[key]
+		if !ok {
+			return nil, false
+		}
+		f, err := e.value.FieldByIndexErr(idx)
+		if err != nil {
+			// nil pointer to embedded struct
+			return NewScalarExprV(nil), true
+		}
+		return wrapValue(f), true
+	case reflect.Map:
+		k := reflect.ValueOf(key).Convert(e.value.Type().Key())
+		m := e.value.MapIndex(k)
+		if !m.IsValid() {
+			return nil, false
+		}
+		return wrapValue(m), true
+	}
+	return nil, false
+}
+
+// Evaluate the expression
+func (e *ObjectExpr) Evaluate(env *Environment) (Expression, error) {
+	return e, nil
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *ObjectExpr) Literal() string {
+	return fmt.Sprintf("(#object:%s#)", e.value.Type())
+}
+
+// Value will provide the wrapped go value
+func (e *ObjectExpr) Value() interface{} {
+	if e.value.CanInterface() {
+		return e.value.Interface()
+	}
+	return nil
+}
+
+// String will provide the string representation of value
+func (e *ObjectExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+// fieldCache holds the member names of struct types, see structFields
+var fieldCache sync.Map
+
+// structFields returns the field index of every exported field in the struct type, including promoted fields.
+// Fields are registered with both their go name and their json tag name, go names taking precedence
+func structFields(t reflect.Type) map[string][]int {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	jsonNames := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() {
+			continue
+		}
+		if _, ok := fields[f.Name]; !ok {
+			fields[f.Name] = f.Index
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		// The shallowest field wins when json names are duplicated
+		if prev, ok := jsonNames[tag]; !ok || len(f.Index) < len(prev) {
+			jsonNames[tag] = f.Index
+		}
+	}
+	for name, idx := range jsonNames {
+		if _, ok := fields[name]; !ok {
+			fields[name] = idx
+		}
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,112 @@
+package expr
+
+import (
+	"testing"
+)
+
+type testCustomer struct {
+	Name    string `json:"name"`
+	Country string
+	secret  string
+}
+
+type testLine struct {
+	Sku   string  `json:"sku"`
+	Qty   int     `json:"qty"`
+	Price float32 `json:"price"`
+}
+
+type testAudit struct {
+	CreatedBy string `json:"created_by"`
+}
+
+type testOrder struct {
+	testAudit
+	ID       int               `json:"id"`
+	Total    float64           `json:"total"`
+	Customer *testCustomer     `json:"customer"`
+	Lines    []testLine        `json:"lines"`
+	Tags     map[string]string `json:"tags"`
+	Note     *string           `json:"note"`
+	Ignored  string            `json:"-"`
+}
+
+func TestBind(t *testing.T) {
+	t.Run("Struct", func(t *testing.T) { RunBindStructTest(t) })
+	t.Run("Map", func(t *testing.T) { RunBindMapTest(t) })
+	t.Run("Lazy", func(t *testing.T) { RunBindLazyTest(t) })
+}
+
+func newTestOrder() *testOrder {
+	return &testOrder{
+		testAudit: testAudit{CreatedBy: "system"},
+		ID:        7,
+		Total:     120.5,
+		Customer:  &testCustomer{Name: "Ada", Country: "NO", secret: "x"},
+		Lines: []testLine{
+			{Sku: "A-1", Qty: 2, Price: 10},
+			{Sku: "B-2", Qty: 0, Price: 2.5},
+		},
+		Tags:    map[string]string{"x-id": "42"},
+		Ignored: "ignored",
+	}
+}
+
+func RunBindStructTest(t *testing.T) {
+	env := NewEnvironment()
+	if err := env.Bind("order", newTestOrder()); err != nil {
+		t.Fatalf("Bind failed: %s", err.Error())
+	}
+	RunExprEnvTest(t, env, "order.total > 100", true)
+	RunExprEnvTest(t, env, "order.Total > 100", true)
+	RunExprEnvTest(t, env, "order.id == 7", true)
+	RunExprEnvTest(t, env, "order.customer.name", "Ada")
+	RunExprEnvTest(t, env, "order.customer.Country", "NO")
+	RunExprEnvTest(t, env, "order['customer']['name']", "Ada")
+	RunExprEnvTest(t, env, "order.customer.secret", nil)
+	RunExprEnvTest(t, env, "order.created_by", "system")
+	RunExprEnvTest(t, env, "order.CreatedBy", "system")
+	RunExprEnvTest(t, env, "order.lines[0].sku", "A-1")
+	RunExprEnvTest(t, env, "order.lines[-1].price * order.lines[-1].qty", float64(0))
+	RunExprEnvTest(t, env, "order.lines[0].qty * 3", int64(6))
+	RunExprEnvTest(t, env, "order.tags['x-id']", "42")
+	RunExprEnvTest(t, env, "order.note", nil)
+	RunExprEnvTest(t, env, "order.Ignored", "ignored")
+	RunExprEnvTest(t, env, "order.missing", nil)
+}
+
+func RunBindMapTest(t *testing.T) {
+	env := NewEnvironment()
+	payload := map[string]interface{}{
+		"user":  map[string]interface{}{"name": "Ada", "roles": []string{"admin", "dev"}},
+		"count": 3,
+	}
+	if err := env.Bind("payload", payload); err != nil {
+		t.Fatalf("Bind failed: %s", err.Error())
+	}
+	RunExprEnvTest(t, env, "payload.user.name", "Ada")
+	RunExprEnvTest(t, env, "'admin' in payload.user.roles", true)
+	RunExprEnvTest(t, env, "payload.count + 1", int64(4))
+	if err := env.Bind("limit", 10); err != nil {
+		t.Fatalf("Bind failed: %s", err.Error())
+	}
+	RunExprEnvTest(t, env, "payload.count < limit", true)
+}
+
+func RunBindLazyTest(t *testing.T) {
+	env := NewEnvironment()
+	order := newTestOrder()
+	env.Bind("order", order)
+	ex, err := env.GetParser().Parse("order.total")
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err.Error())
+	}
+	order.Total = 99
+	res, err := ex.Evaluate(env)
+	if err != nil {
+		t.Fatalf("Eval failed: %s", err.Error())
+	}
+	if res.Value() != float64(99) {
+		t.Errorf("Bound value not resolved at evaluation: %v", res.Value())
+	}
+}
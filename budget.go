@@ -0,0 +1,80 @@
+package expr
+
+import (
+	"fmt"
+)
+
+// Limits are the budgets of an evaluation, used to stop scripts from exhausting the host.
+// The limits apply to each evaluation of a parsed script and to each call of EvaluateContext.
+// A zero value means no limit.
+type Limits struct {
+	// MaxSteps is the maximum number of expressions evaluated
+	MaxSteps int
+	// MaxDepth is the maximum depth of nested function calls.
+	// The depth is always limited by maxCallDepth, so recursive lambdas cannot overflow the go stack
+	MaxDepth int
+	// MaxListSize is the maximum number of elements in a list or map produced by the evaluation
+	MaxListSize int
+	// MaxStringSize is the maximum length in bytes of a string produced by the evaluation
+	MaxStringSize int
+}
+
+// maxCallDepth is the limit of the depth of nested function calls when MaxDepth is not set, or set higher
+const maxCallDepth = 10000
+
+// SetLimits sets the budgets for evaluations in the Environment
+func (e *Environment) SetLimits(limits Limits) {
+	e.limits = limits
+}
+
+// Limits returns the budgets for evaluations in the Environment
+func (e *Environment) Limits() Limits {
+	return e.limits
+}
+
+// ErrBudgetExceeded is returned when an evaluation exceeds one of its Limits
+type ErrBudgetExceeded struct {
+	// Limit is the name of the limit that was exceeded: "steps", "depth", "list size" or "string size"
+	Limit string
+	// Max is the configured value of the limit
+	Max int
+}
+
+func (err *ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf("evaluation budget exceeded: %s (max %d)", err.Limit, err.Max)
+}
+
+// step counts one evaluated expression against the step budget
+func (e *Environment) step() error {
+	if e.run == nil {
+		return nil
+	}
+	e.run.steps++
+	if max := e.run.limits.MaxSteps; max > 0 && e.run.steps > max {
+		return &ErrBudgetExceeded{Limit: "steps", Max: max}
+	}
+	return nil
+}
+
+// checkSize checks a value produced by the evaluation against the size budgets
+func (e *Environment) checkSize(ex Expression) error {
+	if e.run == nil || ex == nil {
+		return nil
+	}
+	limits := e.run.limits
+	switch v := ex.(type) {
+	case *ListExpr:
+		if limits.MaxListSize > 0 && v.Count() > limits.MaxListSize {
+			return &ErrBudgetExceeded{Limit: "list size", Max: limits.MaxListSize}
+		}
+	case *MapExpr:
+		if limits.MaxListSize > 0 && v.Count() > limits.MaxListSize {
+			return &ErrBudgetExceeded{Limit: "list size", Max: limits.MaxListSize}
+		}
+	case *ScalarExpr:
+		if s, ok := v.Value().(string); ok && limits.MaxStringSize > 0 && len(s) > limits.MaxStringSize {
+			return &ErrBudgetExceeded{Limit: "string size", Max: limits.MaxStringSize}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package expr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	t.Run("Steps", func(t *testing.T) { RunBudgetStepsTest(t) })
+	t.Run("Depth", func(t *testing.T) { RunBudgetDepthTest(t) })
+	t.Run("ListSize", func(t *testing.T) { RunBudgetListSizeTest(t) })
+	t.Run("StringSize", func(t *testing.T) { RunBudgetStringSizeTest(t) })
+}
+
+// RunBudgetTest evaluates the script and checks that the expected limit is exceeded.
+// An empty limit expects the evaluation to succeed
+func RunBudgetTest(t *testing.T, env *Environment, script string, limit string) {
+	t.Run(script, func(t *testing.T) {
+		_, err := parseTest(t, env, script).Evaluate(env)
+		var budgetErr *ErrBudgetExceeded
+		if limit == "" {
+			if err != nil {
+				t.Errorf("Eval failed: %s. Error: %s\n", script, err.Error())
+			}
+			return
+		}
+		if !errors.As(err, &budgetErr) || budgetErr.Limit != limit {
+			t.Errorf("Expected %s budget to be exceeded: %s. Error: %v\n", limit, script, err)
+		}
+	})
+}
+
+func RunBudgetStepsTest(t *testing.T) {
+	env := NewEnvironment()
+	env.SetLimits(Limits{MaxSteps: 10})
+	RunBudgetTest(t, env, "1 + 1", "")
+	RunBudgetTest(t, env, "1 + 1 + 1 + 1 + 1 + 1", "steps")
+	RunBudgetTest(t, env, "[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]", "steps")
+
+	// Every evaluation has its own budget
+	ex := parseTest(t, env, "1 + 2 + 3")
+	for i := 0; i < 5; i++ {
+		if _, err := ex.Evaluate(env); err != nil {
+			t.Errorf("Eval failed: %s\n", err.Error())
+		}
+	}
+}
+
+func RunBudgetDepthTest(t *testing.T) {
+	env := NewEnvironment()
+	env.SetLimits(Limits{MaxDepth: 5})
+	recurse := parseTest(t, env, "recurse()")
+	env.RegisterFunction("recurse", func(env *Environment, args []Expression) (Expression, error) {
+		return recurse.Evaluate(env)
+	})
+	env.RegisterFunction("one", func(env *Environment, args []Expression) (Expression, error) {
+		return NewScalarExprV(int64(1)), nil
+	})
+	RunBudgetTest(t, env, "one() + one()", "")
+	RunBudgetTest(t, env, "recurse()", "depth")
+
+	// Unbounded recursion stops at maxCallDepth without a depth budget
+	env = NewEnvironment()
+	RunBudgetTest(t, env, "let f = x => f(x); f(1)", "depth")
+	RunBudgetTest(t, env, "let f = n => n == 0 ? 0 : 1 + f(n - 1); f(1000)", "")
+	RunBudgetTest(t, env, "let f = n => n == 0 ? 0 : 1 + f(n - 1); f(20000)", "depth")
+	env.SetLimits(Limits{MaxDepth: maxCallDepth * 10})
+	RunBudgetTest(t, env, "let f = x => f(x); f(1)", "depth")
+}
+
+func RunBudgetListSizeTest(t *testing.T) {
+	env := NewEnvironment()
+	env.SetLimits(Limits{MaxListSize: 3})
+	env.RegisterGoFunc("range", func(n int) []int { return make([]int, n) })
+	RunBudgetTest(t, env, "[1, 2, 3]", "")
+	RunBudgetTest(t, env, "[1, 2, 3, 4]", "list size")
+	RunBudgetTest(t, env, "{a: 1, b: 2, c: 3, d: 4}", "list size")
+	RunBudgetTest(t, env, "range(3)", "")
+	RunBudgetTest(t, env, "range(1000)", "list size")
+}
+
+func RunBudgetStringSizeTest(t *testing.T) {
+	env := NewEnvironment()
+	env.SetLimits(Limits{MaxStringSize: 5})
+	env.RegisterGoFunc("repeat", strings.Repeat)
+	RunBudgetTest(t, env, "'abc' + 'de'", "")
+	RunBudgetTest(t, env, "'abc' + 'def'", "string size")
+	RunBudgetTest(t, env, "repeat('a', 1000)", "string size")
+}
@@ -5,6 +5,7 @@ package expr
 
 import (
 	"fmt"
+	"sort"
 )
 
 // Print is a built-in expression for simple print to console
@@ -14,3 +15,211 @@ func Print(env *Environment, args []Expression) (Expression, error) {
 	}
 	return env.Get("null"), nil
 }
+
+// Map is a built-in expression in form map(list, x => expr), returning a list of the function applied to every element
+func Map(env *Environment, args []Expression) (Expression, error) {
+	list, f, err := listFuncArgs(env, "map", args, 2)
+	if err != nil {
+		return env.Null(), err
+	}
+	res := NewListExpr()
+	for _, ex := range list.exprs {
+		v, err := call(env, f, ex)
+		if err != nil {
+			return v, err
+		}
+		res.Append(v)
+	}
+	return res, nil
+}
+
+// Filter is a built-in expression in form filter(list, x => cond), returning a list of the elements where cond is true
+func Filter(env *Environment, args []Expression) (Expression, error) {
+	list, f, err := listFuncArgs(env, "filter", args, 2)
+	if err != nil {
+		return env.Null(), err
+	}
+	res := NewListExpr()
+	for _, ex := range list.exprs {
+		v, err := call(env, f, ex)
+		if err != nil {
+			return v, err
+		}
+		if !isFalse(env, v) {
+			res.Append(ex)
+		}
+	}
+	return res, nil
+}
+
+// Any is a built-in expression in form any(list, x => cond), returning true if cond is true for one of the elements
+func Any(env *Environment, args []Expression) (Expression, error) {
+	list, f, err := listFuncArgs(env, "any", args, 2)
+	if err != nil {
+		return env.Null(), err
+	}
+	for _, ex := range list.exprs {
+		v, err := call(env, f, ex)
+		if err != nil {
+			return v, err
+		}
+		if !isFalse(env, v) {
+			return env.True(), nil
+		}
+	}
+	return env.False(), nil
+}
+
+// All is a built-in expression in form all(list, x => cond), returning true if cond is true for all of the elements
+func All(env *Environment, args []Expression) (Expression, error) {
+	list, f, err := listFuncArgs(env, "all", args, 2)
+	if err != nil {
+		return env.Null(), err
+	}
+	for _, ex := range list.exprs {
+		v, err := call(env, f, ex)
+		if err != nil {
+			return v, err
+		}
+		if isFalse(env, v) {
+			return env.False(), nil
+		}
+	}
+	return env.True(), nil
+}
+
+// Reduce is a built-in expression in form reduce(list, (acc, x) => expr, initial), returning the result of
+// applying the function to the accumulated value and every element, starting with initial
+func Reduce(env *Environment, args []Expression) (Expression, error) {
+	list, f, err := listFuncArgs(env, "reduce", args, 3)
+	if err != nil {
+		return env.Null(), err
+	}
+	acc := args[2]
+	for _, ex := range list.exprs {
+		acc, err = call(env, f, acc, ex)
+		if err != nil {
+			return acc, err
+		}
+	}
+	return acc, nil
+}
+
+// SortBy is a built-in expression in form sortBy(list, x => key), returning a list of the elements ordered by key.
+// Keys are compared as in x < y, null keys are ordered first. Elements with equal keys keep their order
+func SortBy(env *Environment, args []Expression) (Expression, error) {
+	list, f, err := listFuncArgs(env, "sortBy", args, 2)
+	if err != nil {
+		return env.Null(), err
+	}
+	keys := make([]Expression, len(list.exprs))
+	for i, ex := range list.exprs {
+		if keys[i], err = call(env, f, ex); err != nil {
+			return keys[i], err
+		}
+	}
+	idx := make([]int, len(keys))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		c, cerr := orderKeys(env, keys[idx[i]], keys[idx[j]])
+		if cerr != nil && err == nil {
+			err = cerr
+		}
+		return c < 0
+	})
+	if err != nil {
+		return env.Null(), fmt.Errorf("sortBy: %s", err.Error())
+	}
+	res := NewListExpr()
+	for _, i := range idx {
+		res.Append(list.exprs[i])
+	}
+	return res, nil
+}
+
+// GroupBy is a built-in expression in form groupBy(list, x => key), returning a map from every key to the list of
+// elements with the key. The keys are strings, in the order they are first seen
+func GroupBy(env *Environment, args []Expression) (Expression, error) {
+	list, f, err := listFuncArgs(env, "groupBy", args, 2)
+	if err != nil {
+		return env.Null(), err
+	}
+	res := NewMapExpr()
+	for _, ex := range list.exprs {
+		k, err := call(env, f, ex)
+		if err != nil {
+			return k, err
+		}
+		key, ok := k.(*ScalarExpr)
+		if !ok {
+			return env.Null(), fmt.Errorf("groupBy: key must be a scalar: %s", k.Literal())
+		}
+		group, ok := res.Get(key.String())
+		if !ok {
+			group = NewListExpr()
+			res.Set(key.String(), group)
+		}
+		group.(*ListExpr).Append(ex)
+	}
+	return res, env.checkSize(res)
+}
+
+// listFuncArgs checks the arguments of a built-in taking a list and a function, and n arguments in total
+func listFuncArgs(env *Environment, name string, args []Expression, n int) (*ListExpr, Function, error) {
+	if len(args) != n {
+		return nil, nil, fmt.Errorf("%s: expected %d arguments, got %d", name, n, len(args))
+	}
+	list, ok := args[0].(*ListExpr)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: argument 1 must be a list: %s", name, args[0].Literal())
+	}
+	f, ok := args[1].(Function)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: argument 2 must be a function: %s", name, args[1].Literal())
+	}
+	return list, f, nil
+}
+
+// call invokes the function from a built-in, counting the call against the depth budget as a call from the script
+func call(env *Environment, f Function, args ...Expression) (Expression, error) {
+	if err := env.check(); err != nil {
+		return env.Null(), err
+	}
+	if err := env.pushStack(exFrame{function: f, args: args}); err != nil {
+		return env.Null(), err
+	}
+	defer env.popStack()
+	res, err := f.Invoke(env, args)
+	if err != nil {
+		return res, err
+	}
+	return res, env.checkSize(res)
+}
+
+// orderKeys returns -1, 0 or 1 as the key l is ordered before, equal to or after r. Null is ordered first
+func orderKeys(env *Environment, l Expression, r Expression) (int, error) {
+	switch {
+	case isNull(l) && isNull(r):
+		return 0, nil
+	case isNull(l):
+		return -1, nil
+	case isNull(r):
+		return 1, nil
+	}
+	ls, lok := l.(*ScalarExpr)
+	rs, rok := r.(*ScalarExpr)
+	if !lok || !rok {
+		return 0, fmt.Errorf("key must be a scalar: %s", r.Literal())
+	}
+	less, err := compare(env, "<", ls, rs)
+	if err != nil {
+		return 0, err
+	}
+	greater, err := compare(env, ">", ls, rs)
+	if err != nil {
+		return 0, err
+	}
+	return order(!isFalse(env, less), !isFalse(env, greater)), nil
+}
@@ -0,0 +1,196 @@
+package expr
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBuiltins(t *testing.T) {
+	t.Run("Lambda", func(t *testing.T) { RunLambdaTest(t) })
+	t.Run("LambdaSyntax", func(t *testing.T) { RunLambdaSyntaxTest(t) })
+	t.Run("HigherOrder", func(t *testing.T) { RunHigherOrderTest(t) })
+	t.Run("HigherOrderErrors", func(t *testing.T) { RunHigherOrderErrorTest(t) })
+	t.Run("Budget", func(t *testing.T) { RunHigherOrderBudgetTest(t) })
+}
+
+// RunBuiltinTest evaluates the script and compares the go value of the result, see goValue()
+func RunBuiltinTest(t *testing.T, env *Environment, script string, expected interface{}) {
+	t.Run(script, func(t *testing.T) {
+		res, err := parseTest(t, env, script).Evaluate(env)
+		if err != nil {
+			t.Errorf("Eval failed: %s. Error: %s\n", script, err.Error())
+			return
+		}
+		if got := goValue(res); !reflect.DeepEqual(got, expected) {
+			t.Errorf("Unexpected result: %s. Got %#v, expected %#v\n", script, got, expected)
+		}
+	})
+}
+
+func RunLambdaTest(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("limit", NewScalarExprV(int64(10)))
+	env.RegisterFunction("apply", func(env *Environment, args []Expression) (Expression, error) {
+		return call(env, args[0].(Function), args[1:]...)
+	})
+	RunBuiltinTest(t, env, "apply(x => x * 2, 21)", int64(42))
+	RunBuiltinTest(t, env, "apply((a, b) => a + b, 1, 2)", int64(3))
+	RunBuiltinTest(t, env, "apply(() => 'none')", "none")
+	RunBuiltinTest(t, env, "apply(x => x > limit, 11)", true)
+	RunBuiltinTest(t, env, "apply(o => o.price > limit ? 'high' : 'low', {price: 5})", "low")
+	RunBuiltinTest(t, env, "apply(x => apply(y => x + y, 2), 1)", int64(3))
+	RunBuiltinTest(t, env, "apply(@'x-id' => @'x-id' + 1, 1)", int64(2))
+	// Parameters shadow the symbols of the environment
+	RunBuiltinTest(t, env, "apply(limit => limit, 1)", int64(1))
+	RunBuiltinTest(t, env, "limit", int64(10))
+	// Parentheses are still sub expressions
+	RunBuiltinTest(t, env, "(limit) * (2 + 1)", int64(30))
+
+	env.Set("rate", NewScalarExprV(0.25))
+	env.Lock("rate", true)
+	errs := map[string]string{
+		"apply(x => x, 1, 2)":           "evaluation error at 1:1: lambda: expected 1 arguments, got 2",
+		"apply(true => 1 == 1, 5)":      "evaluation error at 1:7: symbol true is locked and cannot be bound",
+		"apply(null => missing, 5)":     "evaluation error at 1:7: symbol null is locked and cannot be bound",
+		"apply((a, false) => !a, 1, 2)": "evaluation error at 1:7: symbol false is locked and cannot be bound",
+		"apply(rate => rate, 1)":        "evaluation error at 1:7: symbol rate is locked and cannot be bound",
+	}
+	for script, msg := range errs {
+		_, err := parseTest(t, env, script).Evaluate(env)
+		if err == nil || err.Error() != msg {
+			t.Errorf("Unexpected error: %s. Got %v, expected %s\n", script, err, msg)
+		}
+	}
+
+	// Native functions called from lambdas set expressions in the environment, not in the scope of the lambda
+	env.RegisterFunction("remember", func(env *Environment, args []Expression) (Expression, error) {
+		return args[0], env.Set("last", args[0])
+	})
+	RunBuiltinTest(t, env, "apply(x => remember(x * 2), 21)", int64(42))
+	if last := env.Get("last"); last.Value() != int64(42) {
+		t.Errorf("Unexpected last: %v\n", last.Value())
+	}
+	RunBuiltinTest(t, env, "apply(x => last, 1)", int64(42))
+}
+
+func RunLambdaSyntaxTest(t *testing.T) {
+	ex, err := Parse("f((a, b) => a + b)")
+	if err != nil {
+		t.Fatalf("Parse failed: %s\n", err.Error())
+	}
+	lambda := ex.(*FuncCallExpr).GetArgs()[0]
+	if lambda.Literal() != "((a, b) => (a + b))" {
+		t.Errorf("Unexpected literal: %s\n", lambda.Literal())
+	}
+	RunSyntaxErrorPositionTest(t, "(a, a) => a", 1, 5, "duplicate parameter a")
+	RunSyntaxErrorPositionTest(t, "x =>", 1, 5, "unexpected end of input, expected expression")
+	RunSyntaxErrorPositionTest(t, "(a, 1) => a", 1, 3, "unexpected ,, expected )")
+}
+
+func RunHigherOrderTest(t *testing.T) {
+	env := NewEnvironment()
+	env.RegisterListBuiltins()
+	items := NewListExpr()
+	for _, it := range []struct {
+		name string
+		qty  int64
+		kind string
+	}{{"a", 2, "x"}, {"b", 0, "y"}, {"c", 5, "x"}} {
+		m := NewMapExpr()
+		m.Set("name", NewScalarExprV(it.name))
+		m.Set("qty", NewScalarExprV(it.qty))
+		m.Set("kind", NewScalarExprV(it.kind))
+		items.Append(m)
+	}
+	env.Set("items", items)
+
+	RunBuiltinTest(t, env, "map([1, 2, 3], x => x * 2)", []interface{}{int64(2), int64(4), int64(6)})
+	RunBuiltinTest(t, env, "map(items, i => i.name)", []interface{}{"a", "b", "c"})
+	RunBuiltinTest(t, env, "map([], x => x)", []interface{}{})
+	RunBuiltinTest(t, env, "filter([1, 2, 3, 4], x => x % 2 == 0)", []interface{}{int64(2), int64(4)})
+	RunBuiltinTest(t, env, "map(filter(items, i => i.qty > 1), i => i.name)", []interface{}{"a", "c"})
+	RunBuiltinTest(t, env, "any(items, i => i.qty == 0)", true)
+	RunBuiltinTest(t, env, "any(items, i => i.qty > 5)", false)
+	RunBuiltinTest(t, env, "any([], x => true)", false)
+	RunBuiltinTest(t, env, "all(items, i => i.qty >= 0)", true)
+	RunBuiltinTest(t, env, "all(items, i => i.qty > 0)", false)
+	RunBuiltinTest(t, env, "all([], x => false)", true)
+	RunBuiltinTest(t, env, "reduce(items, (sum, i) => sum + i.qty, 0)", int64(7))
+	RunBuiltinTest(t, env, "reduce([], (acc, x) => acc + x, 'empty')", "empty")
+	RunBuiltinTest(t, env, "map(sortBy(items, i => -i.qty), i => i.name)", []interface{}{"c", "a", "b"})
+	RunBuiltinTest(t, env, "sortBy(['b', null, 'a', 'c'], x => x)", []interface{}{nil, "a", "b", "c"})
+	RunBuiltinTest(t, env, "sortBy([3, 1.5, 2], x => x)", []interface{}{1.5, int64(2), int64(3)})
+	RunBuiltinTest(t, env, "map(sortBy(items, i => i.kind), i => i.name)", []interface{}{"a", "c", "b"})
+	RunBuiltinTest(t, env, "map(groupBy(items, i => i.kind).x, i => i.name)", []interface{}{"a", "c"})
+	RunBuiltinTest(t, env, "groupBy([1, 2, 3, 4], x => x % 2 == 0)", map[string]interface{}{
+		"false": []interface{}{int64(1), int64(3)},
+		"true":  []interface{}{int64(2), int64(4)},
+	})
+
+	// The list built-ins are opt-in, the names are free in a new environment
+	if err := NewEnvironment().RegisterSymbol(*NewSymbolExpr("filter"), NewScalarExprV("x"), false); err != nil {
+		t.Errorf("Register failed: %s\n", err.Error())
+	}
+
+	// Lambdas capture the scope they are defined in
+	limit := NewScalarExprV(int64(1))
+	child := env.NewChild()
+	child.Set("limit", limit)
+	RunBuiltinTest(t, child, "map(filter(items, i => i.qty > limit), i => i.name)", []interface{}{"a", "c"})
+	RunBuiltinTest(t, child, "map([1, 2], x => map([10, 20], y => x + y))", []interface{}{
+		[]interface{}{int64(11), int64(21)},
+		[]interface{}{int64(12), int64(22)},
+	})
+}
+
+func RunHigherOrderErrorTest(t *testing.T) {
+	env := NewEnvironment()
+	env.RegisterListBuiltins()
+	errs := map[string]string{
+		"map([1], x => x, 1)":             "map: expected 2 arguments, got 3",
+		"filter(1, x => x)":               "filter: argument 1 must be a list: 1",
+		"any([1], 1)":                     "any: argument 2 must be a function: 1",
+		"reduce([1], (a, b) => a + b)":    "reduce: expected 3 arguments, got 2",
+		"sortBy([1, 'a'], x => x)":        "sortBy: cannot compare string < int64",
+		"groupBy([1], x => {a: x})":       "groupBy: key must be a scalar: {\"a\": 1}",
+		"all([1, 0], x => 1 / x == 1)":    "integer division by zero",
+		"map([1], (a, b) => a)":           "lambda: expected 2 arguments, got 1",
+		"map([null], x => x.a.b ?? 1)[0]": "",
+	}
+	for script, msg := range errs {
+		_, err := parseTest(t, env, script).Evaluate(env)
+		if msg == "" {
+			if err != nil {
+				t.Errorf("Eval failed: %s. Error: %s\n", script, err.Error())
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected error: %s\n", script)
+			continue
+		}
+		cause := err
+		for ee := (&EvalError{}); errors.As(cause, &ee); {
+			cause = ee.Err
+		}
+		if cause.Error() != msg {
+			t.Errorf("Unexpected error: %s. Got %s, expected %s\n", script, cause.Error(), msg)
+		}
+	}
+}
+
+func RunHigherOrderBudgetTest(t *testing.T) {
+	env := NewEnvironment()
+	env.RegisterListBuiltins()
+	env.SetLimits(Limits{MaxSteps: 100})
+	RunBudgetTest(t, env, "map([1, 2, 3], x => x + 1)", "")
+	RunBudgetTest(t, env, "map([1, 2, 3, 4, 5, 6, 7, 8, 9, 10], x => map([1, 2, 3, 4, 5, 6, 7, 8, 9, 10], y => x + y))", "steps")
+
+	env = NewEnvironment()
+	env.RegisterListBuiltins()
+	// Both the built-in and the lambda are calls
+	env.SetLimits(Limits{MaxDepth: 4})
+	RunBudgetTest(t, env, "map([1], x => map([2], y => x + y))", "")
+	RunBudgetTest(t, env, "map([1], x => map([2], y => map([3], z => map([4], w => w))))", "depth")
+}
@@ -2,312 +2,201 @@ package expr
 
 import (
 	"fmt"
+	"math"
+	"strings"
 )
 
+// numKind is the kind of a numeric value in the promotion lattice.
+// Values are compared and calculated after promotion to the widest type of their kind:
+// signed integers to int64, unsigned integers to uint64 and floats to float64.
+// Two values of different kinds are promoted to the kind that can hold both, float beeing the widest.
+type numKind int
+
+const (
+	notNumber numKind = iota
+	signedNum
+	unsignedNum
+	floatNum
+)
+
+// promote returns the value promoted to int64, uint64 or float64 together with its kind.
+// Values that are not numbers are returned as notNumber
+func promote(v interface{}) (interface{}, numKind) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), signedNum
+	case int8:
+		return int64(n), signedNum
+	case int16:
+		return int64(n), signedNum
+	case int32:
+		return int64(n), signedNum
+	case int64:
+		return n, signedNum
+	case uint:
+		return uint64(n), unsignedNum
+	case uint8:
+		return uint64(n), unsignedNum
+	case uint16:
+		return uint64(n), unsignedNum
+	case uint32:
+		return uint64(n), unsignedNum
+	case uint64:
+		return n, unsignedNum
+	case float32:
+		return float64(n), floatNum
+	case float64:
+		return n, floatNum
+	default:
+		return v, notNumber
+	}
+}
+
+// isNumber reports whether the value is a number in the promotion lattice
+func isNumber(v interface{}) bool {
+	_, k := promote(v)
+	return k != notNumber
+}
+
+// toFloat64 returns the numeric value as float64
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		p, k := promote(v)
+		if k == notNumber {
+			return 0, false
+		}
+		return toFloat64(p)
+	}
+}
+
+// toInt64 returns the numeric integer value as int64. Unsigned values larger than math.MaxInt64 are not converted
+func toInt64(v interface{}) (int64, bool) {
+	p, k := promote(v)
+	switch k {
+	case signedNum:
+		return p.(int64), true
+	case unsignedNum:
+		if u := p.(uint64); u <= math.MaxInt64 {
+			return int64(u), true
+		}
+	}
+	return 0, false
+}
+
+// compareNumbers compares two numbers after promotion. Returns -1, 0 or 1 as l is less, equal or greater than r.
+// ok is false if one of the values is NaN
+func compareNumbers(l interface{}, r interface{}) (c int, ok bool) {
+	pl, kl := promote(l)
+	pr, kr := promote(r)
+	switch {
+	case kl == signedNum && kr == signedNum:
+		return order(pl.(int64) < pr.(int64), pl.(int64) > pr.(int64)), true
+	case kl == unsignedNum && kr == unsignedNum:
+		return order(pl.(uint64) < pr.(uint64), pl.(uint64) > pr.(uint64)), true
+	case kl == signedNum && kr == unsignedNum:
+		if pl.(int64) < 0 {
+			return -1, true
+		}
+		return order(uint64(pl.(int64)) < pr.(uint64), uint64(pl.(int64)) > pr.(uint64)), true
+	case kl == unsignedNum && kr == signedNum:
+		if pr.(int64) < 0 {
+			return 1, true
+		}
+		return order(pl.(uint64) < uint64(pr.(int64)), pl.(uint64) > uint64(pr.(int64))), true
+	}
+	fl, _ := toFloat64(pl)
+	fr, _ := toFloat64(pr)
+	if math.IsNaN(fl) || math.IsNaN(fr) {
+		return 0, false
+	}
+	return order(fl < fr, fl > fr), true
+}
+
+func order(less bool, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
 // Extensive compare of scalar values
 // Will do a compare of the scalareExpr supporting the following operands: '==', '!=', '>=','>','<=','<'
+// Numbers of different types are compared by value after promotion, see promote().
+// Values of incomparable types returns an error.
 func compare(env *Environment, operand string, l *ScalarExpr, r *ScalarExpr) (Expression, error) {
 
 	if r.Value() == nil || r.Value() == env.Null() || l.Value() == nil || l.Value() == env.Null() {
 		return env.False(), nil
 	}
 
-	switch t := l.Value().(type) {
+	switch vl := l.Value().(type) {
 	case bool:
-		vl, lok := l.Value().(bool)
-		vr, rok := r.Value().(bool)
-		if lok && rok {
-			switch operand {
-			case "==":
-				if vl == vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "!=":
-				if vl != vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">=":
-				if vl != vr {
-					if vl {
-						return env.True(), nil
-					}
-					return env.False(), nil
-				}
-				return env.True(), nil
-			case ">":
-				if vl != vr {
-					if vl {
-						return env.True(), nil
-					}
-					return env.False(), nil
-				}
-				return env.False(), nil
-			case "<=":
-				if vl != vr {
-					if vl {
-						return env.False(), nil
-					}
-					return env.True(), nil
-				}
-				return env.True(), nil
-			case "<":
-				if vl != vr {
-					if vl {
-						return env.False(), nil
-					}
-					return env.True(), nil
-				}
-				return env.False(), nil
-			default:
-				return env.False(), fmt.Errorf("operand not supported: %s", operand)
-			}
-		}
-
-	case int:
-		vl, lok := l.Value().(int)
-		vr, rok := r.Value().(int)
-		if lok && rok {
-			switch operand {
-			case "==":
-				if vl == vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "!=":
-				if vl != vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">=":
-				if vl >= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">":
-				if vl > vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<=":
-				if vl <= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<":
-				if vl < vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-
-			default:
-				return env.False(), fmt.Errorf("operand not supported: %s", operand)
-			}
+		vr, ok := r.Value().(bool)
+		if !ok {
+			break
 		}
-	case uint:
-		vl, lok := l.Value().(uint)
-		vr, rok := r.Value().(uint)
-		if lok && rok {
-			switch operand {
-			case "==":
-				if vl == vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "!=":
-				if vl != vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">=":
-				if vl >= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">":
-				if vl > vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<=":
-				if vl <= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<":
-				if vl < vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-
-			default:
-				return env.False(), fmt.Errorf("operand not supported: %s", operand)
-			}
+		// false is ordered before true
+		return compareResult(env, operand, order(!vl && vr, vl && !vr))
+	case string:
+		vr, ok := r.Value().(string)
+		if !ok {
+			break
 		}
-	case int64:
-		vl, lok := l.Value().(int64)
-		vr, rok := r.Value().(int64)
-		if lok && rok {
-			switch operand {
-			case "==":
-				if vl == vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "!=":
-				if vl != vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">=":
-				if vl >= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">":
-				if vl > vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<=":
-				if vl <= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<":
-				if vl < vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-
-			default:
-				return env.False(), fmt.Errorf("operand not supported: %s", operand)
-			}
+		return compareResult(env, operand, strings.Compare(vl, vr))
+	default:
+		if !isNumber(vl) {
+			return env.False(), fmt.Errorf("scalar datatype not supported: %T", vl)
 		}
-	case uint64:
-		vl, lok := l.Value().(uint64)
-		vr, rok := r.Value().(uint64)
-		if lok && rok {
-			switch operand {
-			case "==":
-				if vl == vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "!=":
-				if vl != vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">=":
-				if vl >= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">":
-				if vl > vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<=":
-				if vl <= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<":
-				if vl < vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-
-			default:
-				return env.False(), fmt.Errorf("operand not supported: %s", operand)
-			}
+		if !isNumber(r.Value()) {
+			break
 		}
-	case float32:
-		vl, lok := l.Value().(float32)
-		vr, rok := r.Value().(float32)
-		if lok && rok {
-			switch operand {
-			case "==":
-				if vl == vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "!=":
-				if vl != vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">=":
-				if vl >= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">":
-				if vl > vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<=":
-				if vl <= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<":
-				if vl < vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-
-			default:
-				return env.False(), fmt.Errorf("operand not supported: %s", operand)
+		c, ok := compareNumbers(vl, r.Value())
+		if !ok {
+			// NaN is not equal, less or greater than any number
+			if operand == "!=" {
+				return env.True(), nil
 			}
-		}
-	case float64:
-		vl, lok := l.Value().(float64)
-		vr, rok := r.Value().(float64)
-		if lok && rok {
-			switch operand {
-			case "==":
-				if vl == vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "!=":
-				if vl != vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">=":
-				if vl >= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case ">":
-				if vl > vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<=":
-				if vl <= vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-			case "<":
-				if vl < vr {
-					return env.True(), nil
-				}
-				return env.False(), nil
-
-			default:
+			if !compareOp(operand) {
 				return env.False(), fmt.Errorf("operand not supported: %s", operand)
 			}
+			return env.False(), nil
 		}
+		return compareResult(env, operand, c)
+	}
+	return env.False(), fmt.Errorf("cannot compare %T %s %T", l.Value(), operand, r.Value())
+}
 
+// compareResult returns the boolean result of the operand given the order c of two values
+func compareResult(env *Environment, operand string, c int) (Expression, error) {
+	var res bool
+	switch operand {
+	case "==":
+		res = c == 0
+	case "!=":
+		res = c != 0
+	case ">=":
+		res = c >= 0
+	case ">":
+		res = c > 0
+	case "<=":
+		res = c <= 0
+	case "<":
+		res = c < 0
 	default:
-		return env.False(), fmt.Errorf("scalar datatype not supported: %T", t)
+		return env.False(), fmt.Errorf("operand not supported: %s", operand)
+	}
+	if res {
+		return env.True(), nil
 	}
 	return env.False(), nil
 }
@@ -0,0 +1,122 @@
+package expr
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	t.Run("Bool", func(t *testing.T) { RunCompareBoolTest(t) })
+	t.Run("Numbers", func(t *testing.T) { RunCompareNumberTest(t) })
+	t.Run("Null", func(t *testing.T) { RunCompareNullTest(t) })
+	t.Run("String", func(t *testing.T) { RunCompareStringTest(t) })
+	t.Run("Promotion", func(t *testing.T) { RunComparePromotionTest(t) })
+	t.Run("Incomparable", func(t *testing.T) { RunCompareIncomparableTest(t) })
+	t.Run("HostValues", func(t *testing.T) { RunCompareHostValuesTest(t) })
+}
+
+func RunCompareBoolTest(t *testing.T) {
+	// Expected results for the operands in the order: ==, !=, >=, >, <=, <
+	RunCompareTest(t, true, true, true, false, true, false, true, false)
+	RunCompareTest(t, true, false, false, true, true, true, false, false)
+	RunCompareTest(t, false, true, false, true, false, false, true, true)
+	RunCompareTest(t, false, false, true, false, true, false, true, false)
+}
+
+func RunCompareNumberTest(t *testing.T) {
+	values := [][3]interface{}{
+		{int(1), int(2), int(1)},
+		{uint(1), uint(2), uint(1)},
+		{int64(1), int64(2), int64(1)},
+		{uint64(1), uint64(2), uint64(1)},
+		{float32(1.5), float32(2.5), float32(1.5)},
+		{float64(1.5), float64(2.5), float64(1.5)},
+	}
+	for _, v := range values {
+		small, large, same := v[0], v[1], v[2]
+		// Expected results for the operands in the order: ==, !=, >=, >, <=, <
+		RunCompareTest(t, small, large, false, true, false, false, true, true)
+		RunCompareTest(t, large, small, false, true, true, true, false, false)
+		RunCompareTest(t, small, same, true, false, true, false, true, false)
+	}
+}
+
+func RunCompareNullTest(t *testing.T) {
+	RunCompareTest(t, nil, int64(1), false, false, false, false, false, false)
+	RunCompareTest(t, int64(1), nil, false, false, false, false, false, false)
+}
+
+func RunCompareStringTest(t *testing.T) {
+	RunCompareTest(t, "a", "b", false, true, false, false, true, true)
+	RunCompareTest(t, "b", "a", false, true, true, true, false, false)
+	RunCompareTest(t, "a", "a", true, false, true, false, true, false)
+}
+
+func RunComparePromotionTest(t *testing.T) {
+	// Expected results for the operands in the order: ==, !=, >=, >, <=, <
+	RunCompareTest(t, int64(5), float64(5.0), true, false, true, false, true, false)
+	RunCompareTest(t, int(5), int64(5), true, false, true, false, true, false)
+	RunCompareTest(t, int8(-1), uint(0), false, true, false, false, true, true)
+	RunCompareTest(t, uint16(7), int32(-7), false, true, true, true, false, false)
+	RunCompareTest(t, uint64(math.MaxUint64), int64(math.MaxInt64), false, true, true, true, false, false)
+	RunCompareTest(t, float32(0.5), float64(0.5), true, false, true, false, true, false)
+	RunCompareTest(t, uint8(3), float32(2.5), false, true, true, true, false, false)
+	RunCompareTest(t, math.NaN(), float64(1), false, true, false, false, false, false)
+}
+
+func RunCompareIncomparableTest(t *testing.T) {
+	env := NewEnvironment()
+	values := [][2]interface{}{
+		{true, int64(1)},
+		{"1", int64(1)},
+		{int64(1), "1"},
+		{float64(1), false},
+		{struct{}{}, struct{}{}},
+	}
+	for _, v := range values {
+		if _, err := compare(env, "==", NewScalarExprV(v[0]), NewScalarExprV(v[1])); err == nil {
+			t.Errorf("Compare should fail: %T == %T\n", v[0], v[1])
+		}
+	}
+}
+
+func RunCompareHostValuesTest(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("count", NewScalarExprV(int(5)))
+	env.Set("ratio", NewScalarExprV(float32(0.25)))
+	for _, script := range []string{"count == 5", "count > 4.5", "ratio == 0.25", "ratio * count > 1"} {
+		ex, err := env.GetParser().Parse(script)
+		if err != nil {
+			t.Errorf("Parse failed: %s. Error: %s\n", script, err.Error())
+			continue
+		}
+		res, err := ex.Evaluate(env)
+		if err != nil {
+			t.Errorf("Eval failed: %s. Error: %s\n", script, err.Error())
+			continue
+		}
+		if res.Value() != true {
+			t.Errorf("Compare failed: %s. Evaluated to: %v\n", script, res.Value())
+		}
+	}
+}
+
+// RunCompareTest runs compare() for all operands on the values l and r.
+// Expected results are given in the order: ==, !=, >=, >, <=, <
+func RunCompareTest(t *testing.T, l interface{}, r interface{}, expected ...bool) {
+	env := NewEnvironment()
+	for i, operand := range []string{"==", "!=", ">=", ">", "<=", "<"} {
+		name := NewScalarExprV(l).Literal() + " " + operand + " " + NewScalarExprV(r).Literal()
+		exp := expected[i]
+		t.Run(name, func(t *testing.T) {
+			res, err := compare(env, operand, NewScalarExprV(l), NewScalarExprV(r))
+			if err != nil {
+				t.Errorf("Compare failed: %T %s %T. Error: %s\n", l, operand, r, err.Error())
+				return
+			}
+			if res.Value() != exp {
+				t.Errorf("Compare failed: %T(%v) %s %T(%v). Evaluated to: %v\n", l, l, operand, r, r, res.Value())
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package expr
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentEvaluation(t *testing.T) {
+	t.Run("SharedEnvironment", func(t *testing.T) { RunConcurrentSharedEnvironmentTest(t) })
+	t.Run("Autoregister", func(t *testing.T) { RunConcurrentAutoregisterTest(t) })
+	t.Run("Lock", func(t *testing.T) { RunConcurrentLockTest(t) })
+}
+
+func RunConcurrentSharedEnvironmentTest(t *testing.T) {
+	env := NewEnvironment()
+	env.SetLimits(Limits{MaxDepth: 8})
+	env.RegisterGoFunc("multiply", func(a, b int64) int64 { return a * b })
+	env.RegisterGoFunc("nested", func(env *Environment, s string) (Expression, error) {
+		ex, err := env.GetParser().Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		return ex.Evaluate(env)
+	})
+	env.Bind("order", newTestOrder())
+	ex := parseTest(t, env, "nested('multiply(order.id, 6)') == 42 && order.lines[0].sku in ['A-1'] ? 'ok' : 'fail'")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				// Writers and readers share the environment
+				env.Set(fmt.Sprintf("request%d", g), NewScalarExprV(int64(i)))
+				res, err := ex.Evaluate(env)
+				if err != nil {
+					t.Errorf("Eval failed: %s\n", err.Error())
+					return
+				}
+				if res.Value() != "ok" {
+					t.Errorf("Eval failed, evaluated to: %v\n", res.Value())
+					return
+				}
+			}
+			env.Remove(fmt.Sprintf("request%d", g))
+		}(g)
+	}
+	wg.Wait()
+}
+
+func RunConcurrentAutoregisterTest(t *testing.T) {
+	env := NewEnvironment()
+	env.AutoregisterGlobals = true
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				// parseTest calls t.Fatalf, which must not be called from the goroutines of the test
+				ex, err := env.GetParser().Parse(fmt.Sprintf("missing%d == null", i%10))
+				if err != nil {
+					t.Errorf("Parse failed: %s\n", err.Error())
+					return
+				}
+				if _, err := ex.Evaluate(env); err != nil {
+					t.Errorf("Eval failed: %s\n", err.Error())
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if _, ok := env.lookup("missing9"); !ok {
+		t.Errorf("Missing symbol was not registered")
+	}
+}
+
+func RunConcurrentLockTest(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("limit", NewScalarExprV(int64(10)))
+	env.Lock("limit", true)
+	if err := env.Set("limit", NewScalarExprV(int64(20))); err == nil {
+		t.Errorf("Locked symbol was modified")
+	}
+	env.Lock("limit", false)
+	if err := env.Set("limit", NewScalarExprV(int64(20))); err != nil {
+		t.Errorf("Unlocked symbol could not be modified: %s", err.Error())
+	}
+	if err := env.Set("true", NewScalarExprV(false)); err == nil {
+		t.Errorf("Builtin symbol was modified")
+	}
+}
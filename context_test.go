@@ -0,0 +1,79 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEvaluateContext(t *testing.T) {
+	t.Run("Value", func(t *testing.T) { RunContextValueTest(t) })
+	t.Run("Cancelled", func(t *testing.T) { RunContextCancelledTest(t) })
+	t.Run("Deadline", func(t *testing.T) { RunContextDeadlineTest(t) })
+	t.Run("StopBetweenNodes", func(t *testing.T) { RunContextStopBetweenNodesTest(t) })
+}
+
+func parseTest(t *testing.T, env *Environment, script string) Expression {
+	ex, err := env.GetParser().Parse(script)
+	if err != nil {
+		t.Fatalf("Parse failed: %s. Error: %s\n", script, err.Error())
+	}
+	return ex
+}
+
+func RunContextValueTest(t *testing.T) {
+	env := NewEnvironment()
+	res, err := EvaluateContext(context.Background(), parseTest(t, env, "2 * 21"), env)
+	if err != nil || res.Value() != int64(42) {
+		t.Errorf("EvaluateContext failed: %v %v", res, err)
+	}
+	if env.Context() != context.Background() {
+		t.Errorf("Context() should return context.Background() outside EvaluateContext")
+	}
+}
+
+func RunContextCancelledTest(t *testing.T) {
+	env := NewEnvironment()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := EvaluateContext(ctx, parseTest(t, env, "1 + 1"), env)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+}
+
+func RunContextDeadlineTest(t *testing.T) {
+	env := NewEnvironment()
+	env.RegisterGoFunc("wait", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := EvaluateContext(ctx, parseTest(t, env, "wait()"), env)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+	}
+}
+
+func RunContextStopBetweenNodesTest(t *testing.T) {
+	env := NewEnvironment()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	env.RegisterGoFunc("stop", func(env *Environment) bool {
+		if env.Context() != ctx {
+			t.Errorf("Native function did not receive the evaluation context")
+		}
+		cancel()
+		return true
+	})
+	env.RegisterGoFunc("never", func() bool {
+		t.Errorf("Evaluation continued after the context was cancelled")
+		return true
+	})
+	_, err := EvaluateContext(ctx, parseTest(t, env, "[stop(), never()]"), env)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+}
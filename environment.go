@@ -1,6 +1,7 @@
 package expr
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -9,11 +10,50 @@ import (
 // Environment is is used for registering expressions and holding the parser.
 // Environment is set up with a basic set of expressions by calling RegisterBuiltins()
 // Environment are further extendable by RegisterSymbol(), RegisterFunction() and RegisterScopedFunction()
+// Environment is safe for concurrent use. The registered expressions are guarded, and every evaluation has its own call stack,
+// so one configured Environment can evaluate parsed scripts from many goroutines
 type Environment struct {
-	exStack             exStack
 	parser              Parser
-	globalFuncs         map[string]eValue
+	globalFuncs         *symbolTable
+	parent              *Environment
 	AutoregisterGlobals bool
+	limits              Limits
+	run                 *evaluation
+	locals              *binding
+}
+
+// evaluation holds the state of one evaluation, see withContext
+type evaluation struct {
+	ctx     context.Context
+	limits  Limits
+	steps   int
+	exStack exStack
+	known   []knownValue
+}
+
+// knownValue is an expression already evaluated to value, see evaluateKnown
+type knownValue struct {
+	expr  Expression
+	value Expression
+}
+
+// binding is a local binding of a lambda parameter or a let expression, see withLocals.
+// Bindings are kept apart from the registered expressions, so expressions set by native functions are set in the environment
+type binding struct {
+	name  string
+	expr  Expression
+	bound bool
+	next  *binding
+}
+
+// get returns the expression bound to the name, skipping bindings not bound yet
+func (b *binding) get(name string) (Expression, bool) {
+	for ; b != nil; b = b.next {
+		if b.bound && b.name == name {
+			return b.expr, true
+		}
+	}
+	return nil, false
 }
 
 // eValue is used for keeping global registered functions
@@ -22,6 +62,77 @@ type eValue struct {
 	readOnly bool
 }
 
+// symbolTable holds the registered expressions of an Environment, guarded for concurrent use
+type symbolTable struct {
+	lock   sync.RWMutex
+	values map[string]eValue
+}
+
+func newSymbolTable() *symbolTable {
+	return &symbolTable{values: make(map[string]eValue)}
+}
+
+func (t *symbolTable) get(name string) (eValue, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	val, ok := t.values[name]
+	return val, ok
+}
+
+// getOrRegister returns the registered value, registering def if the name is not registered
+func (t *symbolTable) getOrRegister(name string, def eValue) eValue {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	val, ok := t.values[name]
+	if !ok {
+		val = def
+		t.values[name] = val
+	}
+	return val
+}
+
+// register registers a new value, failing if the name is registered
+func (t *symbolTable) register(name string, val eValue) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if _, ok := t.values[name]; ok {
+		return fmt.Errorf("symbol already defined: " + name)
+	}
+	t.values[name] = val
+	return nil
+}
+
+// set replaces the expression of a registered value, failing if the value is locked
+func (t *symbolTable) set(name string, expr Expression) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	val := t.values[name]
+	if val.readOnly {
+		return fmt.Errorf("symbol %s cannot be modified", name)
+	}
+	val.expr = expr
+	t.values[name] = val
+	return nil
+}
+
+// setLocked locks or unlocks a value, registering def if the name is not registered
+func (t *symbolTable) setLocked(name string, def eValue, locked bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	val, ok := t.values[name]
+	if !ok {
+		val = def
+	}
+	val.readOnly = locked
+	t.values[name] = val
+}
+
+func (t *symbolTable) remove(name string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.values, name)
+}
+
 // NewEnvironment returns a new Environment ready set up with a minimum of bultin expressions
 // Environment is is used for registering expressions and holding the parser.
 // Environment is set up with a basic set of expressions by calling RegisterBuiltins()
@@ -35,7 +146,7 @@ type eValue struct {
 func NewEnvironment() *Environment {
 	e := new(Environment)
 	e.parser = newParser()
-	e.globalFuncs = make(map[string]eValue)
+	e.globalFuncs = newSymbolTable()
 	e.registerBuiltIns()
 	return e
 }
@@ -60,6 +171,20 @@ func (e *Environment) registerBuiltIns() error {
 	return nil
 }
 
+// RegisterListBuiltins registers the higher-order functions applying a function, like x => x.qty > 0, to the elements of a list:
+// 'map', 'filter', 'any', 'all', 'reduce', 'sortBy' and 'groupBy'.
+// The functions are not registered by NewEnvironment, as the names are common names of symbols.
+// Expressions already registered with the names are replaced
+func (e *Environment) RegisterListBuiltins() {
+	e.RegisterFunction("map", Map)
+	e.RegisterFunction("filter", Filter)
+	e.RegisterFunction("any", Any)
+	e.RegisterFunction("all", All)
+	e.RegisterFunction("reduce", Reduce)
+	e.RegisterFunction("sortBy", SortBy)
+	e.RegisterFunction("groupBy", GroupBy)
+}
+
 // True returns a True expression from the environment
 func (e *Environment) True() Expression {
 	return e.Get("true")
@@ -95,76 +220,239 @@ func (e *Environment) RegisterScopedFunction(name string, scope Expression, call
 	return scopeFunc
 }
 
+// NewChild returns a new Environment with its own scope, reading through to e for expressions not registered in the child.
+// Expressions set in the child are not visible in e, and expressions locked in e cannot be set in the child.
+// Use a child to layer per request data on top of an Environment with the registered functions, instead of
+// setting and removing the data in the shared Environment.
+func (e *Environment) NewChild() *Environment {
+	c := new(Environment)
+	c.parser = e.parser
+	c.globalFuncs = newSymbolTable()
+	c.parent = e
+	c.AutoregisterGlobals = e.AutoregisterGlobals
+	c.limits = e.limits
+	c.run = e.run
+	return c
+}
+
+// Parent returns the Environment the child was created from, nil if e is not a child
+func (e *Environment) Parent() *Environment {
+	return e.parent
+}
+
+// resolve a local binding or a registered value in the environment or its parents
+func (e *Environment) resolve(name string) (eValue, bool) {
+	for s := e; s != nil; s = s.parent {
+		if expr, ok := s.locals.get(name); ok {
+			return eValue{expr: expr}, true
+		}
+		if val, ok := s.globalFuncs.get(name); ok {
+			return val, true
+		}
+	}
+	return eValue{}, false
+}
+
+// lockedInParent returns an error if the name is locked in one of the parents
+func (e *Environment) lockedInParent(name string) error {
+	for p := e.parent; p != nil; p = p.parent {
+		if val, ok := p.globalFuncs.get(name); ok && val.readOnly {
+			return fmt.Errorf("symbol %s cannot be modified", name)
+		}
+	}
+	return nil
+}
+
+// checkBinding returns an error if the name is locked in the environment or one of its parents.
+// Locked symbols, like true and null, cannot be shadowed by local bindings
+func (e *Environment) checkBinding(name string) error {
+	for s := e; s != nil; s = s.parent {
+		if val, ok := s.globalFuncs.get(name); ok && val.readOnly {
+			return fmt.Errorf("symbol %s is locked and cannot be bound", name)
+		}
+	}
+	return nil
+}
+
+// withLocals returns an environment like e with the local bindings locals.
+// Registered expressions are shared with e, so expressions set in the returned environment are set in e
+func (e *Environment) withLocals(locals *binding) *Environment {
+	b := new(Environment)
+	b.parser = e.parser
+	b.globalFuncs = e.globalFuncs
+	b.parent = e.parent
+	b.AutoregisterGlobals = e.AutoregisterGlobals
+	b.limits = e.limits
+	b.run = e.run
+	b.locals = locals
+	return b
+}
+
 // RegisterSymbol is used for registering symbols in the Environment
 func (e *Environment) RegisterSymbol(symbol SymbolExpr, expr Expression, immutable bool) error {
 	name := symbol.Literal()
-	if _, ok := e.globalFuncs[name]; ok {
-		return fmt.Errorf("symbol already defined: " + name)
+	if err := e.lockedInParent(name); err != nil {
+		return err
 	}
-	val := eValue{readOnly: immutable}
-	val.expr = expr
-	e.globalFuncs[name] = val
-	return nil
+	return e.globalFuncs.register(name, eValue{expr: expr, readOnly: immutable})
 }
 
 // Set registers a new expression in the environment
+// In a child environment the expression is set in the child, see NewChild()
 func (e *Environment) Set(name string, expr Expression) error {
-	var val eValue
-	var ok bool
-	if val, ok = e.globalFuncs[name]; !ok {
-		val = eValue{readOnly: false}
+	if err := e.lockedInParent(name); err != nil {
+		return err
 	}
-	if val.readOnly {
-		return fmt.Errorf("symbol %s cannot be modified", name)
-	}
-	val.expr = expr
-	e.globalFuncs[name] = val
-	return nil
+	return e.globalFuncs.set(name, expr)
 }
 
 // Get a registered expression in the environment
+// In a child environment, expressions not registered in the child are read from the parent
 func (e *Environment) Get(name string) Expression {
-	var val eValue
-	var ok bool
-	if val, ok = e.globalFuncs[name]; !ok {
+	val, ok := e.resolve(name)
+	if !ok {
 		if !e.AutoregisterGlobals {
 			return e.Null()
 		}
-		val = eValue{expr: e.Null(), readOnly: false}
-		e.globalFuncs[name] = val
+		val = e.globalFuncs.getOrRegister(name, eValue{expr: e.Null(), readOnly: false})
 	}
 	return val.expr
 }
 
+// lookup a registered expression in the environment, reporting whether it was found.
+// Unlike Get, missing symbols are never registered
+func (e *Environment) lookup(name string) (Expression, bool) {
+	val, ok := e.resolve(name)
+	return val.expr, ok
+}
+
 // Lock a registered expression in the environment. If the expression does not exist, a null expression will be registered
+// In a child environment an expression registered in the parent is registered in the child with its current value and locked there
 func (e *Environment) Lock(name string, locked bool) {
-	var val eValue
-	var ok bool
-	if val, ok = e.globalFuncs[name]; !ok {
-		val = eValue{expr: e.Null()}
-		e.globalFuncs[name] = val
+	def, ok := e.resolve(name)
+	if !ok {
+		def = eValue{expr: e.Null()}
 	}
-	val.readOnly = locked
+	e.globalFuncs.setLocked(name, eValue{expr: def.expr}, locked)
 }
 
 // Remove removes a registered expression in the environment
+// In a child environment only expressions registered in the child are removed
 func (e *Environment) Remove(name string) {
-	delete(e.globalFuncs, name)
+	e.globalFuncs.remove(name)
 }
 
-// Pushes function information to a stack. Used when functions are evaluated
-func (e *Environment) pushStack(frm exFrame) {
-	e.exStack.push(frm)
+// Pushes function information to the stack of the evaluation. Used when functions are evaluated
+// Returns ErrBudgetExceeded when the stack is deeper than the depth budget, or maxCallDepth when there is no depth budget
+func (e *Environment) pushStack(frm exFrame) error {
+	if e.run == nil {
+		return errors.New("function invoked outside an evaluation")
+	}
+	max := e.run.limits.MaxDepth
+	if max <= 0 || max > maxCallDepth {
+		max = maxCallDepth
+	}
+	if e.run.exStack.len() >= max {
+		return &ErrBudgetExceeded{Limit: "depth", Max: max}
+	}
+	e.run.exStack.push(frm)
+	return nil
 }
 
 // Pops the previous function information from the stack. Used after current function is evaluated
 func (e *Environment) popStack() {
-	e.exStack.pop()
+	if e.run != nil {
+		e.run.exStack.pop()
+	}
 }
 
 //lint:ignore U1000 Ignore unused function temporarily for debugging
 func (e *Environment) peek() (exFrame, error) {
-	return e.exStack.peek()
+	if e.run == nil {
+		return exFrame{}, errors.New("Empty Stack")
+	}
+	return e.run.exStack.peek()
+}
+
+// EvaluateContext evaluates the expression in the environment, stopping the evaluation when the context is cancelled
+// or its deadline is exceeded. The context is checked between the evaluation of each expression and before functions
+// are invoked, and it is available to native functions through Environment.Context().
+// The error returned when the evaluation is stopped wraps ctx.Err().
+func EvaluateContext(ctx context.Context, ex Expression, env *Environment) (Expression, error) {
+	ev := env.withContext(ctx)
+	return ev.evaluate(ex)
+}
+
+// Context returns the context of the current evaluation. context.Background() is returned when
+// the expression is not evaluated with EvaluateContext.
+// Native functions doing long running work should stop when the context is done.
+func (e *Environment) Context() context.Context {
+	if e.run == nil || e.run.ctx == nil {
+		return context.Background()
+	}
+	return e.run.ctx
+}
+
+// withContext returns an environment for one evaluation with the context and the limits of e.
+// Registered expressions are shared with e, the call stack and the budgets are not.
+func (e *Environment) withContext(ctx context.Context) *Environment {
+	ev := new(Environment)
+	ev.parser = e.parser
+	ev.globalFuncs = e.globalFuncs
+	ev.parent = e.parent
+	ev.AutoregisterGlobals = e.AutoregisterGlobals
+	ev.limits = e.limits
+	ev.run = &evaluation{ctx: ctx, limits: e.limits}
+	ev.locals = e.locals
+	return ev
+}
+
+// check returns an error if the current evaluation should stop
+func (e *Environment) check() error {
+	if e.run == nil || e.run.ctx == nil {
+		return nil
+	}
+	if err := e.run.ctx.Err(); err != nil {
+		return fmt.Errorf("evaluation stopped: %w", err)
+	}
+	return nil
+}
+
+// evaluate a sub expression in the environment, checking whether the evaluation should stop first.
+// Expressions evaluating sub expressions should use evaluate rather than calling Evaluate directly.
+// Expressions evaluated outside an evaluation, like the expression returned by Parse, starts a new evaluation
+func (e *Environment) evaluate(ex Expression) (Expression, error) {
+	if e.run == nil {
+		return e.withContext(context.Background()).evaluate(ex)
+	}
+	for i := len(e.run.known) - 1; i >= 0; i-- {
+		if e.run.known[i].expr == ex {
+			return e.run.known[i].value, nil
+		}
+	}
+	if err := e.check(); err != nil {
+		return e.Null(), err
+	}
+	if err := e.step(); err != nil {
+		return e.Null(), err
+	}
+	res, err := ex.Evaluate(e)
+	if err != nil {
+		return res, evalError(ex, err)
+	}
+	return res, nil
+}
+
+// evaluateKnown evaluates ex, where the expression known is already evaluated to value.
+// Evaluating known within ex gives value without evaluating it again, see SafeNavExpr
+func (e *Environment) evaluateKnown(ex Expression, known Expression, value Expression) (Expression, error) {
+	if e.run == nil {
+		return e.withContext(context.Background()).evaluateKnown(ex, known, value)
+	}
+	n := len(e.run.known)
+	e.run.known = append(e.run.known, knownValue{expr: known, value: value})
+	defer func() { e.run.known = e.run.known[:n] }()
+	return e.evaluate(ex)
 }
 
 // GetParser gets return the parser used by the Envionment
@@ -189,6 +477,12 @@ func (s *exStack) push(ef exFrame) {
 	s.frm = append(s.frm, ef)
 }
 
+func (s *exStack) len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.frm)
+}
+
 func (s *exStack) pop() (exFrame, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -0,0 +1,71 @@
+package expr
+
+import (
+	"testing"
+)
+
+func TestEnvironment(t *testing.T) {
+	t.Run("Child", func(t *testing.T) { RunEnvironmentChildTest(t) })
+	t.Run("ChildLocked", func(t *testing.T) { RunEnvironmentChildLockedTest(t) })
+}
+
+func RunEnvironmentChildTest(t *testing.T) {
+	env := NewEnvironment()
+	env.RegisterGoFunc("multiply", func(a, b int64) int64 { return a * b })
+	env.Set("rate", NewScalarExprV(int64(2)))
+
+	child := env.NewChild()
+	child.Set("qty", NewScalarExprV(int64(21)))
+	child.Set("rate", NewScalarExprV(int64(3)))
+	if child.Parent() != env {
+		t.Errorf("Parent() should return the parent environment")
+	}
+
+	// The child reads through to the parent, its own symbols shadowing the parent
+	RunExprEnvTest(t, child, "multiply(qty, 2) == 42", true)
+	RunExprEnvTest(t, child, "rate", int64(3))
+	RunExprEnvTest(t, child, "true && !false", true)
+	// Child symbols are not visible in the parent
+	RunExprEnvTest(t, env, "qty", nil)
+	RunExprEnvTest(t, env, "rate", int64(2))
+
+	grandChild := child.NewChild()
+	RunExprEnvTest(t, grandChild, "qty * rate", int64(63))
+
+	// Removing in the child does not remove from the parent
+	child.Remove("rate")
+	RunExprEnvTest(t, child, "rate", int64(2))
+	child.Remove("multiply")
+	RunExprEnvTest(t, child, "multiply(1, 1)", int64(1))
+}
+
+func RunEnvironmentChildLockedTest(t *testing.T) {
+	env := NewEnvironment()
+	env.Set("limit", NewScalarExprV(int64(10)))
+	env.Lock("limit", true)
+	child := env.NewChild()
+	if err := child.Set("limit", NewScalarExprV(int64(20))); err == nil {
+		t.Errorf("Symbol locked in parent was set in child")
+	}
+	if err := child.Set("null", NewScalarExprV(int64(1))); err == nil {
+		t.Errorf("Builtin symbol was set in child")
+	}
+	if err := child.NewChild().RegisterSymbol(*NewSymbolExpr("limit"), NewScalarExprV(int64(1)), false); err == nil {
+		t.Errorf("Symbol locked in parent was registered in grandchild")
+	}
+	RunExprEnvTest(t, child, "limit", int64(10))
+
+	// Locking a symbol of the parent in the child keeps its value
+	env = NewEnvironment()
+	env.Set("limit", NewScalarExprV(int64(10)))
+	child = env.NewChild()
+	child.Lock("limit", true)
+	RunExprEnvTest(t, child, "limit", int64(10))
+	if err := child.Set("limit", NewScalarExprV(int64(20))); err == nil {
+		t.Errorf("Symbol locked in child was set")
+	}
+	if err := env.Set("limit", NewScalarExprV(int64(20))); err != nil {
+		t.Errorf("Symbol locked in child was locked in parent: %s\n", err.Error())
+	}
+	RunExprEnvTest(t, env, "limit", int64(20))
+}
@@ -0,0 +1,160 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Position is a location in the source of a script
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number in characters, starting at 1
+}
+
+// IsValid reports whether the position is known
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position on the form line:column
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// snippet returns the source line of the position followed by a line with a caret under the position
+func snippet(source string, pos Position) string {
+	if !pos.IsValid() || pos.Offset > len(source) {
+		return ""
+	}
+	lineStart := strings.LastIndexByte(source[:pos.Offset], '\n') + 1
+	lineEnd := strings.IndexByte(source[pos.Offset:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(source)
+	} else {
+		lineEnd += pos.Offset
+	}
+	line := strings.TrimSuffix(source[lineStart:lineEnd], "\r")
+	// Keep tabs to have the caret aligned with the source line
+	var caret strings.Builder
+	for _, r := range source[lineStart:pos.Offset] {
+		if r == '\t' {
+			caret.WriteRune('\t')
+		} else {
+			caret.WriteRune(' ')
+		}
+	}
+	caret.WriteRune('^')
+	return line + "\n" + caret.String()
+}
+
+// Span is the location of an expression in the source, from the start position up to the end position.
+// The end position is the position following the expression
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// IsValid reports whether the span is known
+func (s Span) IsValid() bool {
+	return s.Start.IsValid()
+}
+
+// String returns the span on the form line:column-line:column
+func (s Span) String() string {
+	return fmt.Sprintf("%s-%s", s.Start, s.End)
+}
+
+// Spanned is implemented by expressions remembering their span in the source.
+// Every expression created by the parser implements Spanned, expressions created otherwise returns an invalid span
+type Spanned interface {
+	Span() Span
+}
+
+// srcSpan is embedded in expressions to implement Spanned
+type srcSpan struct {
+	span   *Span
+	source string
+}
+
+// Span returns the span of the expression in the source
+func (s *srcSpan) Span() Span {
+	if s.span == nil {
+		return Span{}
+	}
+	return *s.span
+}
+
+func (s *srcSpan) setSpan(span Span, source string) {
+	s.span = &span
+	s.source = source
+}
+
+// spanSource returns the source the span is in, used for the snippet of errors
+func (s *srcSpan) spanSource() string {
+	return s.source
+}
+
+// spanOf returns the span of the expression, the span is invalid if it is not known
+func spanOf(ex Expression) Span {
+	if sp, ok := ex.(Spanned); ok {
+		return sp.Span()
+	}
+	return Span{}
+}
+
+//=============================================================================
+
+// SyntaxError is returned by the parser when the source of a script is not valid
+type SyntaxError struct {
+	Position
+	Token   Token  // the offending token
+	Msg     string // description of the problem
+	Snippet string // the source line followed by a line with a caret under the offending token
+}
+
+// Error returns the message prefixed with the position
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %s: %s", e.Position, e.Msg)
+}
+
+//=============================================================================
+
+// EvalError is returned when the evaluation of a parsed script fails.
+// The position is the start of the expression that failed, see Unwrap for the cause
+type EvalError struct {
+	Position
+	End     Position   // the end of the expression that failed
+	Expr    Expression // the expression that failed
+	Err     error      // the cause
+	Snippet string     // the source line followed by a line with a caret under the failing expression
+}
+
+// Error returns the message of the cause prefixed with the position
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("evaluation error at %s: %s", e.Position, e.Err.Error())
+}
+
+// Unwrap returns the cause
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// evalError wraps an error of the failing expression in an EvalError with the position of the expression.
+// Errors already wrapped and errors of expressions without position are returned as is
+func evalError(ex Expression, err error) error {
+	var ee *EvalError
+	if errors.As(err, &ee) {
+		return err
+	}
+	sp, ok := ex.(Spanned)
+	if !ok || !sp.Span().IsValid() {
+		return err
+	}
+	ee = &EvalError{Position: sp.Span().Start, End: sp.Span().End, Expr: ex, Err: err}
+	if src, ok := ex.(interface{ spanSource() string }); ok && src.spanSource() != "" {
+		ee.Snippet = snippet(src.spanSource(), ee.Position)
+	}
+	return ee
+}
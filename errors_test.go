@@ -0,0 +1,171 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	t.Run("Syntax", func(t *testing.T) { RunSyntaxErrorTest(t) })
+	t.Run("Snippet", func(t *testing.T) { RunSyntaxErrorSnippetTest(t) })
+	t.Run("Trailing", func(t *testing.T) { RunSyntaxErrorTrailingTest(t) })
+	t.Run("Recovery", func(t *testing.T) { RunSyntaxErrorRecoveryTest(t) })
+	t.Run("Eval", func(t *testing.T) { RunEvalErrorTest(t) })
+	t.Run("EvalCause", func(t *testing.T) { RunEvalErrorCauseTest(t) })
+}
+
+func RunSyntaxErrorPositionTest(t *testing.T, script string, line int, column int, msg string) {
+	_, err := Parse(script)
+	var se *SyntaxError
+	if !errors.As(err, &se) {
+		t.Errorf("Expected syntax error: %q. Error: %v\n", script, err)
+		return
+	}
+	if se.Line != line || se.Column != column || se.Msg != msg {
+		t.Errorf("Unexpected syntax error: %q. Got %s %q, expected %d:%d %q\n", script, se.Position, se.Msg, line, column, msg)
+	}
+}
+
+func RunSyntaxErrorTest(t *testing.T) {
+	RunSyntaxErrorPositionTest(t, "1 + ", 1, 5, "unexpected end of input, expected expression")
+	RunSyntaxErrorPositionTest(t, "a ? 1 2", 1, 7, "unexpected 2, expected :")
+	RunSyntaxErrorPositionTest(t, "(1 + 2", 1, 7, "unexpected end of input, expected )")
+	RunSyntaxErrorPositionTest(t, "f(1 2)", 1, 5, "unexpected 2, expected , or )")
+	RunSyntaxErrorPositionTest(t, "a.b(1,", 1, 7, "unexpected end of input, expected )")
+	RunSyntaxErrorPositionTest(t, "x[]", 1, 3, "missing index")
+	RunSyntaxErrorPositionTest(t, "x[1 2]", 1, 5, "unexpected 2, expected ]")
+	RunSyntaxErrorPositionTest(t, "x.1", 1, 3, "unexpected 1, expected identifier")
+	RunSyntaxErrorPositionTest(t, "[1, 2", 1, 6, "unexpected end of input, expected , or ]")
+	RunSyntaxErrorPositionTest(t, "{1: 2}", 1, 2, "unexpected 1, expected map key")
+	RunSyntaxErrorPositionTest(t, "1 +\n  * 2", 2, 3, "unexpected *, expected expression")
+	RunSyntaxErrorPositionTest(t, "'a' +\n 'bc", 2, 2, "unterminated quoted string")
+	RunSyntaxErrorPositionTest(t, "'æøå' + )", 1, 9, "unexpected ), expected expression")
+
+	se := &SyntaxError{}
+	if _, err := Parse("1 + )"); !errors.As(err, &se) || se.Token.Literal != ")" || se.Offset != 4 {
+		t.Errorf("Unexpected token or offset: %v %v\n", se.Token, err)
+	}
+	if ex, err := Parse(""); err != nil || ex == nil {
+		t.Errorf("Empty script should parse: %v\n", err)
+	}
+}
+
+func RunSyntaxErrorSnippetTest(t *testing.T) {
+	_, err := Parse("a &&\n\tb == )")
+	se := &SyntaxError{}
+	if !errors.As(err, &se) {
+		t.Fatalf("Expected syntax error: %v\n", err)
+	}
+	if se.Snippet != "\tb == )\n\t     ^" {
+		t.Errorf("Unexpected snippet:\n%s\n", se.Snippet)
+	}
+	if se.Error() != "syntax error at 2:7: unexpected ), expected expression" {
+		t.Errorf("Unexpected message: %s\n", se.Error())
+	}
+}
+
+func RunSyntaxErrorTrailingTest(t *testing.T) {
+	RunSyntaxErrorPositionTest(t, "1 + 2 3", 1, 7, "unexpected 3, expected end of input")
+	RunSyntaxErrorPositionTest(t, "a b", 1, 3, "unexpected b, expected end of input")
+	RunSyntaxErrorPositionTest(t, "f(1))", 1, 5, "unexpected ), expected end of input")
+	RunSyntaxErrorPositionTest(t, "[1], 2", 1, 4, "unexpected ,, expected end of input")
+}
+
+func RunSyntaxErrorRecoveryTest(t *testing.T) {
+	tests := map[string][]string{
+		"f(1 +, [2 3], {a 1}) + )": {
+			"syntax error at 1:6: unexpected ,, expected expression",
+			"syntax error at 1:11: unexpected 3, expected , or ]",
+			"syntax error at 1:18: unexpected 1, expected :",
+			"syntax error at 1:24: unexpected ), expected expression",
+		},
+		"(1 + ) * [1,,2] ) x(": {
+			"syntax error at 1:6: unexpected ), expected expression",
+			"syntax error at 1:13: unexpected ,, expected expression",
+			"syntax error at 1:17: unexpected ), expected end of input",
+			"syntax error at 1:21: unexpected end of input, expected )",
+		},
+		"f((1 + (2 *": {
+			"syntax error at 1:12: unexpected end of input, expected expression",
+		},
+		"a.b(1 2, 3 4)": {
+			"syntax error at 1:7: unexpected 2, expected , or )",
+			"syntax error at 1:12: unexpected 4, expected , or )",
+		},
+		"'a' + 'b": {
+			"syntax error at 1:7: unterminated quoted string",
+		},
+		"let a = 1 +; let b = ); a + * b": {
+			"syntax error at 1:12: unexpected ;, expected expression",
+			"syntax error at 1:22: unexpected ), expected expression",
+			"syntax error at 1:29: unexpected *, expected expression",
+		},
+	}
+	for script, msgs := range tests {
+		ex, errs := ParseAll(script)
+		if ex != nil || len(errs) != len(msgs) {
+			t.Errorf("Unexpected diagnostics: %q. Got %v\n", script, errs)
+			continue
+		}
+		for i, err := range errs {
+			if err.Error() != msgs[i] {
+				t.Errorf("Unexpected diagnostic: %q. Got %s, expected %s\n", script, err.Error(), msgs[i])
+			}
+		}
+	}
+	env := NewEnvironment()
+	ex, errs := env.GetParser().ParseAll("[1, 2,][1]")
+	if len(errs) > 0 {
+		t.Fatalf("ParseAll failed: %v\n", errs)
+	}
+	if res, err := ex.Evaluate(env); err != nil || res.Value() != int64(2) {
+		t.Errorf("Unexpected result: %v %v\n", res, err)
+	}
+}
+
+func RunEvalErrorTest(t *testing.T) {
+	env := NewEnvironment()
+	tests := map[string]Span{
+		"1 + 1 / 0":           {Start: Position{4, 1, 5}, End: Position{9, 1, 10}},
+		"1 == 1 &&\n 1 < 'a'": {Start: Position{11, 2, 2}, End: Position{18, 2, 9}},
+		"-'a'":                {Start: Position{0, 1, 1}, End: Position{4, 1, 5}},
+		"null[1]":             {Start: Position{0, 1, 1}, End: Position{7, 1, 8}},
+		"[1, 2, 3]['a']":      {Start: Position{0, 1, 1}, End: Position{14, 1, 15}},
+		"1 in 2":              {Start: Position{0, 1, 1}, End: Position{6, 1, 7}},
+	}
+	for script, span := range tests {
+		_, err := parseTest(t, env, script).Evaluate(env)
+		ee := &EvalError{}
+		if !errors.As(err, &ee) {
+			t.Errorf("Expected evaluation error: %q. Error: %v\n", script, err)
+			continue
+		}
+		if ee.Position != span.Start || ee.End != span.End || ee.Expr == nil || ee.Snippet == "" {
+			t.Errorf("Unexpected evaluation error: %q. Got %s-%s, expected %s\n", script, ee.Position, ee.End, span)
+		}
+	}
+}
+
+func RunEvalErrorCauseTest(t *testing.T) {
+	env := NewEnvironment()
+	cause := errors.New("failed")
+	env.RegisterFunction("fail", func(env *Environment, args []Expression) (Expression, error) {
+		return env.Null(), cause
+	})
+	_, err := parseTest(t, env, "1 +\n  fail()").Evaluate(env)
+	ee := &EvalError{}
+	if !errors.As(err, &ee) || !errors.Is(err, cause) {
+		t.Fatalf("Expected evaluation error with cause: %v\n", err)
+	}
+	if ee.Snippet != "  fail()\n  ^" || ee.Error() != "evaluation error at 2:3: failed" {
+		t.Errorf("Unexpected evaluation error: %s\n%s\n", ee.Error(), ee.Snippet)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = parseTest(t, env, "1; 1 + 1").(*ScriptExpr).EvaluateContext(ctx, env)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled: %v\n", err)
+	}
+}
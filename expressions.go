@@ -1,8 +1,10 @@
 package expr
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -25,11 +27,187 @@ type Function interface {
 	Invoke(env *Environment, args []Expression) (Expression, error)
 }
 
+// Accessor is implemented by expressions holding members that can be accessed by key,
+// on the form obj.key or obj['key']
+type Accessor interface {
+	Expression
+	Member(key string) (Expression, bool)
+}
+
+//=============================================================================
+
+// ScriptExpr is the root expression of a parsed script.
+// Each evaluation of the script has its own call stack and budgets, see Environment.SetLimits
+type ScriptExpr struct {
+	srcSpan
+	source string
+	body   Expression
+}
+
+// NewScriptExpr registers a new script with the source and the parsed body
+func NewScriptExpr(source string, body Expression) *ScriptExpr {
+	e := ScriptExpr{}
+	e.source = source
+	e.body = body
+	return &e
+}
+
+// Source returns the script source
+func (e *ScriptExpr) Source() string {
+	return e.source
+}
+
+// Body returns the parsed expression of the script
+func (e *ScriptExpr) Body() Expression {
+	return e.body
+}
+
+// Evaluate the expression. A script evaluated outside an evaluation starts a new evaluation.
+// Runtime failures of parsed scripts are returned as an *EvalError
+func (e *ScriptExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return EvaluateContext(context.Background(), e, env)
+	}
+	res, err := env.evaluate(e.body)
+	var ee *EvalError
+	if errors.As(err, &ee) && ee.Snippet == "" {
+		ee.Snippet = snippet(e.source, ee.Position)
+	}
+	return res, err
+}
+
+// EvaluateContext evaluates the script as EvaluateContext(ctx, script, env)
+func (e *ScriptExpr) EvaluateContext(ctx context.Context, env *Environment) (Expression, error) {
+	return EvaluateContext(ctx, e, env)
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *ScriptExpr) Literal() string {
+	return e.body.Literal()
+}
+
+// Value will provide value after evaluation
+func (e *ScriptExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *ScriptExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// SequenceExpr is a sequence of expressions on the form expr; expr; expr, evaluating to the last expression
+type SequenceExpr struct {
+	srcSpan
+	exprs []Expression
+}
+
+// NewSequenceExpr registers a new sequence of the expressions
+func NewSequenceExpr(exprs ...Expression) *SequenceExpr {
+	e := SequenceExpr{}
+	e.exprs = exprs
+	return &e
+}
+
+// Evaluate the expression. The expressions are evaluated in order and the value of the last is returned
+func (e *SequenceExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	res := env.Null()
+	for _, ex := range e.exprs {
+		var err error
+		res, err = env.evaluate(ex)
+		if err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *SequenceExpr) Literal() string {
+	literals := make([]string, 0, len(e.exprs))
+	for _, ex := range e.exprs {
+		literals = append(literals, ex.Literal())
+	}
+	return "(" + strings.Join(literals, "; ") + ")"
+}
+
+// Value will provide value after evaluation
+func (e *SequenceExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *SequenceExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// LetExpr is a local binding on the form let name = value; body.
+// The name is bound locally in the evaluation, and is only visible in value and body. Locked symbols cannot be bound.
+// Lambdas in value can refer to the name, making recursive lambdas possible
+type LetExpr struct {
+	srcSpan
+	name  string
+	value Expression
+	body  Expression
+}
+
+// NewLetExpr registers a new binding of the name to value, visible in body
+func NewLetExpr(name string, value Expression, body Expression) *LetExpr {
+	e := LetExpr{}
+	e.name = name
+	e.value = value
+	e.body = body
+	return &e
+}
+
+// Evaluate the expression
+func (e *LetExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	if err := env.checkBinding(e.name); err != nil {
+		return env.Null(), err
+	}
+	local := &binding{name: e.name, next: env.locals}
+	scope := env.withLocals(local)
+	v, err := scope.evaluate(e.value)
+	if err != nil {
+		return v, err
+	}
+	// The binding is visible to lambdas captured by value from now on
+	local.expr = v
+	local.bound = true
+	return scope.evaluate(e.body)
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *LetExpr) Literal() string {
+	return fmt.Sprintf("(let %s = %s; %s)", e.name, e.value.Literal(), e.body.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *LetExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *LetExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
 //=============================================================================
 
 // SymbolExpr is  used for attaching functions to extend the Environment
 // Symbols can even be registered in a scope!
 type SymbolExpr struct {
+	srcSpan
 	name  string
 	scope *SymbolExpr
 }
@@ -50,7 +228,27 @@ func NewSymbolExprWithScope(name string, scope *SymbolExpr) *SymbolExpr {
 }
 
 // Evaluate the expression
+// A scoped symbol registered in the environment with its full literal takes precedence,
+// otherwise the symbol is looked up as a member of the evaluated scope
 func (e *SymbolExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	if e.scope == nil {
+		return env.Get(e.name), nil
+	}
+	if ex, ok := env.lookup(e.Literal()); ok {
+		return ex, nil
+	}
+	s, err := env.evaluate(e.scope)
+	if err != nil {
+		return s, err
+	}
+	if a, ok := s.(Accessor); ok {
+		if m, ok := a.Member(e.name); ok {
+			return m, nil
+		}
+	}
 	return env.Get(e.Literal()), nil
 }
 
@@ -76,6 +274,7 @@ func (e *SymbolExpr) String() string {
 
 // ScalarExpr is a basic scalar expression
 type ScalarExpr struct {
+	srcSpan
 	literal string
 	value   interface{}
 }
@@ -105,8 +304,11 @@ func NewScalarExprV(value interface{}) *ScalarExpr {
 	return &sc
 }
 
+// escape escapes the string with go escape sequences, to be quoted by double quotes.
+// The lexer decodes the escape sequences, making the literals of string scalars parse to the same string
 func escape(s string) string {
-	return strings.ReplaceAll(strings.ReplaceAll(s, "\\", "\\\\"), "\"", "\\\"")
+	q := strconv.Quote(s)
+	return q[1 : len(q)-1]
 }
 
 // Evaluate the expression
@@ -132,10 +334,26 @@ func (e *ScalarExpr) String() string {
 	return "NULL"
 }
 
+// isFalse reports whether an evaluated expression is considered false in a logical context.
+// nil, null and false are false, everything else is true
+func isFalse(env *Environment, c Expression) bool {
+	if c == nil || c == env.False() || c.Value() == nil || c.Value() == env.False() {
+		return true
+	}
+	b, ok := c.Value().(bool)
+	return ok && !b
+}
+
+// isNull reports whether the expression is null
+func isNull(c Expression) bool {
+	return c == nil || c.Value() == nil
+}
+
 //=============================================================================
 
 // CondExpr is a conditional expression on the form cond? left: right
 type CondExpr struct {
+	srcSpan
 	condition Expression
 	left      Expression
 	right     Expression
@@ -152,14 +370,17 @@ func NewCondExpr(cond Expression, left Expression, right Expression) *CondExpr {
 
 // Evaluate the expression
 func (e *CondExpr) Evaluate(env *Environment) (Expression, error) {
-	c, err := e.condition.Evaluate(env)
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	c, err := env.evaluate(e.condition)
 	if err != nil {
 		return c, err
 	}
-	if c == nil || c == env.False() || c.Value() == nil || c.Value() == env.False() {
-		return e.right.Evaluate(env)
+	if isFalse(env, c) {
+		return env.evaluate(e.right)
 	}
-	return e.left.Evaluate(env)
+	return env.evaluate(e.left)
 }
 
 // Literal will provide a uniqe literal for the expression
@@ -179,8 +400,56 @@ func (e *CondExpr) String() string {
 
 //=============================================================================
 
+// CoalesceExpr is a null-coalescing expression on the form left ?? right, evaluating to left unless left is null
+type CoalesceExpr struct {
+	srcSpan
+	left  Expression
+	right Expression
+}
+
+// NewCoalesceExpr registers a new null-coalescing expression on the form left??right
+func NewCoalesceExpr(left Expression, right Expression) *CoalesceExpr {
+	e := CoalesceExpr{}
+	e.left = left
+	e.right = right
+	return &e
+}
+
+// Evaluate the expression. The right expression is only evaluated when left is null
+func (e *CoalesceExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	c, err := env.evaluate(e.left)
+	if err != nil {
+		return c, err
+	}
+	if !isNull(c) {
+		return c, nil
+	}
+	return env.evaluate(e.right)
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *CoalesceExpr) Literal() string {
+	return fmt.Sprintf("(%s ?? %s)", e.left.Literal(), e.right.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *CoalesceExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *CoalesceExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
 // OrExpr is a basic binary expression(||)
 type OrExpr struct {
+	srcSpan
 	left  Expression
 	right Expression
 }
@@ -195,18 +464,21 @@ func NewOrExpr(left Expression, right Expression) *OrExpr {
 
 // Evaluate the expression
 func (e *OrExpr) Evaluate(env *Environment) (Expression, error) {
-	c, err := e.left.Evaluate(env)
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	c, err := env.evaluate(e.left)
 	if err != nil {
 		return c, err
 	}
-	if c != nil && c != env.False() && c.Value() != nil && c.Value() != env.False() {
+	if !isFalse(env, c) {
 		return env.True(), nil
 	}
-	c, err = e.right.Evaluate(env)
+	c, err = env.evaluate(e.right)
 	if err != nil {
 		return c, err
 	}
-	if c != nil && c != env.False() && c.Value() != nil && c.Value() != env.False() {
+	if !isFalse(env, c) {
 		return env.True(), nil
 	}
 	return env.False(), nil
@@ -231,6 +503,7 @@ func (e *OrExpr) String() string {
 
 // AndExpr is a basic binary expression(&&)
 type AndExpr struct {
+	srcSpan
 	left  Expression
 	right Expression
 }
@@ -245,18 +518,21 @@ func NewAndExpr(left Expression, right Expression) *AndExpr {
 
 // Evaluate the expression
 func (e *AndExpr) Evaluate(env *Environment) (Expression, error) {
-	c, err := e.left.Evaluate(env)
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	c, err := env.evaluate(e.left)
 	if err != nil {
 		return c, err
 	}
-	if c == nil || c == env.False() || c.Value() == nil || c.Value() == env.False() {
+	if isFalse(env, c) {
 		return env.False(), nil
 	}
-	c, err = e.right.Evaluate(env)
+	c, err = env.evaluate(e.right)
 	if err != nil {
 		return c, err
 	}
-	if c == nil || c == env.False() || c.Value() == nil || c.Value() == env.False() {
+	if isFalse(env, c) {
 		return env.False(), nil
 	}
 	return env.True(), nil
@@ -279,10 +555,102 @@ func (e *AndExpr) String() string {
 
 //=============================================================================
 
+// NotExpr is a basic unary expression(!)
+type NotExpr struct {
+	srcSpan
+	operand Expression
+}
+
+// NewNotExpr registers a new logical not expression on the form !operand
+func NewNotExpr(operand Expression) *NotExpr {
+	e := NotExpr{}
+	e.operand = operand
+	return &e
+}
+
+// Evaluate the expression
+func (e *NotExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	c, err := env.evaluate(e.operand)
+	if err != nil {
+		return c, err
+	}
+	if isFalse(env, c) {
+		return env.True(), nil
+	}
+	return env.False(), nil
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *NotExpr) Literal() string {
+	return fmt.Sprintf("(!%s)", e.operand.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *NotExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *NotExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// NegateExpr is a basic unary expression(-)
+type NegateExpr struct {
+	srcSpan
+	operand Expression
+}
+
+// NewNegateExpr registers a new numeric negation expression on the form -operand
+func NewNegateExpr(operand Expression) *NegateExpr {
+	e := NegateExpr{}
+	e.operand = operand
+	return &e
+}
+
+// Evaluate the expression
+func (e *NegateExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	c, err := env.evaluate(e.operand)
+	if err != nil {
+		return c, err
+	}
+	s, ok := c.(*ScalarExpr)
+	if !ok || !isNumber(s.Value()) {
+		return env.Null(), fmt.Errorf("negation is only supported on numbers: %s", e.Literal())
+	}
+	return negate(env, s)
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *NegateExpr) Literal() string {
+	return fmt.Sprintf("(-%s)", e.operand.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *NegateExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *NegateExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
 // CompareExpr is a basic compare expression handling the following compare operands:
 // '==', '!=', '>=','>','<=','<'
-// only scalar expression with same value type is compared
+// only scalar expressions are compared, numbers of different types are compared by value
 type CompareExpr struct {
+	srcSpan
 	operand string
 	left    Expression
 	right   Expression
@@ -299,14 +667,17 @@ func NewCompareExpr(operand string, left Expression, right Expression) *CompareE
 }
 
 // Evaluate the expression, will do a compare supporting the following operands: '==', '!=', '>=','>','<=','<'
-// Only scalar expression with same value type is compared
+// Only scalar expressions are compared, numbers of different types are compared by value
 func (e *CompareExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
 
-	l, err := e.left.Evaluate(env)
+	l, err := env.evaluate(e.left)
 	if err != nil {
 		return l, err
 	}
-	r, err := e.right.Evaluate(env)
+	r, err := env.evaluate(e.right)
 	if err != nil {
 		return r, err
 	}
@@ -337,6 +708,7 @@ func (e *CompareExpr) String() string {
 
 // ConCatExpr is a basic concatenation expression
 type ConCatExpr struct {
+	srcSpan
 	left  Expression
 	right Expression
 }
@@ -351,27 +723,36 @@ func NewConcatExpr(left Expression, right Expression) *ConCatExpr {
 
 // Evaluate the expression
 func (e *ConCatExpr) Evaluate(env *Environment) (Expression, error) {
-	l, err := e.left.Evaluate(env)
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	l, err := env.evaluate(e.left)
 	if err != nil {
 		return l, err
 	}
-	r, err := e.right.Evaluate(env)
+	r, err := env.evaluate(e.right)
 	if err != nil {
 		return r, err
 	}
+	res := concat(l, r)
+	return res, env.checkSize(res)
+}
+
+// concat joins the string representation of two evaluated expressions. Null values are treated as empty strings
+func concat(l Expression, r Expression) Expression {
 	if l == nil || l.Value() == nil {
 		if r == nil || r.Value() == nil {
 
-			return NewScalarExpr("", ""), nil
+			return NewScalarExpr("", "")
 		}
-		return NewScalarExpr("", r.String()), nil
+		return NewScalarExpr("", r.String())
 	}
 
 	if r == nil || r.Value() == nil {
-		return NewScalarExpr("", r.String()), nil
+		return NewScalarExpr("", l.String())
 	}
 
-	return NewScalarExpr("", l.String()+r.String()), nil
+	return NewScalarExpr("", l.String()+r.String())
 }
 
 // Literal will provide a uniqe literal for the expression
@@ -391,8 +772,131 @@ func (e *ConCatExpr) String() string {
 
 //=============================================================================
 
+// InterpolationExpr is a string with embedded expressions, on the form `text ${expr} text`
+type InterpolationExpr struct {
+	srcSpan
+	parts []Expression
+}
+
+// NewInterpolationExpr registers a new interpolation of the parts, where every part is joined to a string
+func NewInterpolationExpr(parts ...Expression) *InterpolationExpr {
+	e := InterpolationExpr{}
+	e.parts = parts
+	return &e
+}
+
+// Evaluate the expression. The string representation of every part is joined, null values are treated as empty strings
+func (e *InterpolationExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	var sb strings.Builder
+	for _, p := range e.parts {
+		v, err := env.evaluate(p)
+		if err != nil {
+			return v, err
+		}
+		if v != nil && v.Value() != nil {
+			sb.WriteString(v.String())
+		}
+	}
+	res := NewScalarExprV(sb.String())
+	return res, env.checkSize(res)
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *InterpolationExpr) Literal() string {
+	var sb strings.Builder
+	sb.WriteString("`")
+	for _, p := range e.parts {
+		if s, ok := p.(*ScalarExpr); ok {
+			if str, ok := s.Value().(string); ok {
+				sb.WriteString(strings.ReplaceAll(str, "${", "$${"))
+				continue
+			}
+		}
+		sb.WriteString("${" + p.Literal() + "}")
+	}
+	sb.WriteString("`")
+	return sb.String()
+}
+
+// Value will provide value after evaluation
+func (e *InterpolationExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *InterpolationExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// ArithmeticExpr is a basic arithmetic expression handling the following operands:
+// '+', '-', '*', '/', '%', '**'
+// '+' falls back to concatenation when one of the values is not a number
+type ArithmeticExpr struct {
+	srcSpan
+	operand string
+	left    Expression
+	right   Expression
+}
+
+// NewArithmeticExpr registers a new arithmetic expression on the form left operand right
+func NewArithmeticExpr(operand string, left Expression, right Expression) *ArithmeticExpr {
+	e := ArithmeticExpr{}
+	e.operand = operand
+	e.left = left
+	e.right = right
+	return &e
+}
+
+// Evaluate the expression, supporting the following operands: '+', '-', '*', '/', '%', '**'
+func (e *ArithmeticExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	l, err := env.evaluate(e.left)
+	if err != nil {
+		return l, err
+	}
+	r, err := env.evaluate(e.right)
+	if err != nil {
+		return r, err
+	}
+	ls, lok := l.(*ScalarExpr)
+	rs, rok := r.(*ScalarExpr)
+	if lok && rok && isNumber(ls.Value()) && isNumber(rs.Value()) {
+		return arithmetic(env, e.operand, ls, rs)
+	}
+	if e.operand == "+" {
+		res := concat(l, r)
+		return res, env.checkSize(res)
+	}
+	return env.Null(), fmt.Errorf("operand %s is only supported on numbers: %s", e.operand, e.Literal())
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *ArithmeticExpr) Literal() string {
+	return fmt.Sprintf("(%s %s %s)", e.left.Literal(), e.operand, e.right.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *ArithmeticExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *ArithmeticExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
 // ListExpr is a epression for list definitions
 type ListExpr struct {
+	srcSpan
 	exprs []Expression
 }
 
@@ -415,22 +919,25 @@ func (e *ListExpr) Count() int {
 }
 
 // Get the expression at index, careful! Preferrably used together with Count()
-// Does not check for out of bound referencing
+// Does not check for out of bound referencing. Scripts use the bounds checked IndexExpr, list[index]
 func (e *ListExpr) Get(idx int) Expression {
 	return e.exprs[idx]
 }
 
 // Evaluate the expression
 func (e *ListExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
 	expr := NewListExpr()
 	for _, ex := range e.exprs {
-		le, err := ex.Evaluate(env)
+		le, err := env.evaluate(ex)
 		if err != nil {
 			return le, err
 		}
 		expr.Append(le)
 	}
-	return expr, nil
+	return expr, env.checkSize(expr)
 
 }
 
@@ -458,8 +965,402 @@ func (e *ListExpr) String() string {
 
 //=============================================================================
 
+// MapExpr is a expression for map definitions, keeping the order of the keys
+type MapExpr struct {
+	srcSpan
+	keys  []string
+	exprs map[string]Expression
+}
+
+// NewMapExpr registers a new map expression in form {key: expr,....}
+func NewMapExpr() *MapExpr {
+	e := MapExpr{}
+	e.keys = make([]string, 0)
+	e.exprs = make(map[string]Expression)
+	return &e
+}
+
+// Set adds or replaces the expression with the key
+func (e *MapExpr) Set(key string, expr Expression) {
+	if _, ok := e.exprs[key]; !ok {
+		e.keys = append(e.keys, key)
+	}
+	e.exprs[key] = expr
+}
+
+// Get the expression with the key and whether the key exists
+func (e *MapExpr) Get(key string) (Expression, bool) {
+	ex, ok := e.exprs[key]
+	return ex, ok
+}
+
+// Member for implementation of accessor interface
+func (e *MapExpr) Member(key string) (Expression, bool) {
+	return e.Get(key)
+}
+
+// Keys return the keys in the order they were added
+func (e *MapExpr) Keys() []string {
+	return e.keys
+}
+
+// Count return number of keys in the map
+func (e *MapExpr) Count() int {
+	return len(e.keys)
+}
+
+// Evaluate the expression
+func (e *MapExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	expr := NewMapExpr()
+	for _, k := range e.keys {
+		me, err := env.evaluate(e.exprs[k])
+		if err != nil {
+			return me, err
+		}
+		expr.Set(k, me)
+	}
+	return expr, env.checkSize(expr)
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *MapExpr) Literal() string {
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, k := range e.keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(fmt.Sprintf("\"%s\": %s", escape(k), e.exprs[k].Literal()))
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// Value will provide value after evaluation
+func (e *MapExpr) Value() interface{} {
+	return e
+}
+
+// String will provide the string representation of value
+func (e *MapExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// IndexExpr is a expression for accessing an element of the evaluated target, on the form target[index]
+// Lists and strings are indexed by integer, negative indexes counts from the end. Maps are indexed by key
+type IndexExpr struct {
+	srcSpan
+	target Expression
+	index  Expression
+}
+
+// NewIndexExpr registers a new index expression in form target[index]
+func NewIndexExpr(target Expression, index Expression) *IndexExpr {
+	e := IndexExpr{}
+	e.target = target
+	e.index = index
+	return &e
+}
+
+// Evaluate the expression
+func (e *IndexExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	t, err := env.evaluate(e.target)
+	if err != nil {
+		return t, err
+	}
+	idx, err := env.evaluate(e.index)
+	if err != nil {
+		return idx, err
+	}
+	if t == nil || t.Value() == nil {
+		return env.Null(), fmt.Errorf("cannot index null value: %s", e.Literal())
+	}
+	if a, ok := t.(Accessor); ok {
+		key, ok := idx.Value().(string)
+		if !ok {
+			return env.Null(), fmt.Errorf("key must be a string: %s", e.Literal())
+		}
+		return member(env, a, key), nil
+	}
+	i, ok := toInt64(idx.Value())
+	if !ok {
+		return env.Null(), fmt.Errorf("index must be an integer: %s", e.Literal())
+	}
+	switch v := t.Value().(type) {
+	case *ListExpr:
+		n, err := position(i, v.Count(), e)
+		if err != nil {
+			return env.Null(), err
+		}
+		return v.Get(n), nil
+	case string:
+		runes := []rune(v)
+		n, err := position(i, len(runes), e)
+		if err != nil {
+			return env.Null(), err
+		}
+		return NewScalarExprV(string(runes[n])), nil
+	default:
+		return env.Null(), fmt.Errorf("cannot index %T: %s", t.Value(), e.Literal())
+	}
+}
+
+// position returns the index i in a sequence of length n. Negative indexes counts from the end of the sequence
+func position(i int64, n int, e Expression) (int, error) {
+	p := i
+	if p < 0 {
+		p += int64(n)
+	}
+	if p < 0 || p >= int64(n) {
+		return 0, fmt.Errorf("index %d out of range [0:%d]: %s", i, n, e.Literal())
+	}
+	return int(p), nil
+}
+
+// member returns the member of the accessor with the key or null if the key does not exist
+func member(env *Environment, a Accessor, key string) Expression {
+	if m, ok := a.Member(key); ok {
+		return m
+	}
+	return env.Null()
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *IndexExpr) Literal() string {
+	return fmt.Sprintf("%s[%s]", e.target.Literal(), e.index.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *IndexExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *IndexExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// SliceExpr is a expression for slicing the evaluated list or string, on the form target[from:to]
+// Both from and to are optional. Negative values counts from the end
+type SliceExpr struct {
+	srcSpan
+	target Expression
+	from   Expression
+	to     Expression
+}
+
+// NewSliceExpr registers a new slice expression in form target[from:to]. from and to may be nil
+func NewSliceExpr(target Expression, from Expression, to Expression) *SliceExpr {
+	e := SliceExpr{}
+	e.target = target
+	e.from = from
+	e.to = to
+	return &e
+}
+
+// Evaluate the expression
+func (e *SliceExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	t, err := env.evaluate(e.target)
+	if err != nil {
+		return t, err
+	}
+	if t == nil || t.Value() == nil {
+		return env.Null(), fmt.Errorf("cannot slice null value: %s", e.Literal())
+	}
+	var n int
+	switch v := t.Value().(type) {
+	case *ListExpr:
+		n = v.Count()
+	case string:
+		n = len([]rune(v))
+	default:
+		return env.Null(), fmt.Errorf("cannot slice %T: %s", t.Value(), e.Literal())
+	}
+	from, err := e.bound(env, e.from, 0, n)
+	if err != nil {
+		return env.Null(), err
+	}
+	to, err := e.bound(env, e.to, n, n)
+	if err != nil {
+		return env.Null(), err
+	}
+	if from > to {
+		return env.Null(), fmt.Errorf("slice bounds out of range [%d:%d]: %s", from, to, e.Literal())
+	}
+	switch v := t.Value().(type) {
+	case *ListExpr:
+		res := NewListExpr()
+		res.exprs = append(res.exprs, v.exprs[from:to]...)
+		return res, nil
+	default:
+		return NewScalarExprV(string([]rune(v.(string))[from:to])), nil
+	}
+}
+
+// bound evaluates a slice bound in a sequence of length n. def is used when the bound is omitted
+func (e *SliceExpr) bound(env *Environment, b Expression, def int, n int) (int, error) {
+	if b == nil {
+		return def, nil
+	}
+	v, err := env.evaluate(b)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := toInt64(v.Value())
+	if !ok {
+		return 0, fmt.Errorf("slice index must be an integer: %s", e.Literal())
+	}
+	p := i
+	if p < 0 {
+		p += int64(n)
+	}
+	if p < 0 || p > int64(n) {
+		return 0, fmt.Errorf("slice index %d out of range [0:%d]: %s", i, n, e.Literal())
+	}
+	return int(p), nil
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *SliceExpr) Literal() string {
+	from, to := "", ""
+	if e.from != nil {
+		from = e.from.Literal()
+	}
+	if e.to != nil {
+		to = e.to.Literal()
+	}
+	return fmt.Sprintf("%s[%s:%s]", e.target.Literal(), from, to)
+}
+
+// Value will provide value after evaluation
+func (e *SliceExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *SliceExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// MemberExpr is a expression for accessing a member of the evaluated target, on the form target.name
+// Used when the target is not a symbol, like {key: expr}.key or function().key
+type MemberExpr struct {
+	srcSpan
+	target Expression
+	name   string
+}
+
+// NewMemberExpr registers a new member expression in form target.name
+func NewMemberExpr(target Expression, name string) *MemberExpr {
+	e := MemberExpr{}
+	e.target = target
+	e.name = name
+	return &e
+}
+
+// Evaluate the expression
+func (e *MemberExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	t, err := env.evaluate(e.target)
+	if err != nil {
+		return t, err
+	}
+	if t == nil || t.Value() == nil {
+		return env.Null(), fmt.Errorf("cannot access member of null value: %s", e.Literal())
+	}
+	a, ok := t.(Accessor)
+	if !ok {
+		return env.Null(), fmt.Errorf("cannot access member of %T: %s", t.Value(), e.Literal())
+	}
+	return member(env, a, e.name), nil
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *MemberExpr) Literal() string {
+	return fmt.Sprintf("%s.%s", e.target.Literal(), e.name)
+}
+
+// Value will provide value after evaluation
+func (e *MemberExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *MemberExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// SafeNavExpr is a safe navigation expression on the form target?.name, where access is the chain following target,
+// like target.name or target.name(args)[0]. The expression evaluates to null when target is null, otherwise to access.
+// Target is evaluated once, access gets the value of target without evaluating it again
+type SafeNavExpr struct {
+	srcSpan
+	target Expression
+	access Expression
+}
+
+// NewSafeNavExpr registers a new safe navigation expression evaluating access unless target is null
+func NewSafeNavExpr(target Expression, access Expression) *SafeNavExpr {
+	e := SafeNavExpr{}
+	e.target = target
+	e.access = access
+	return &e
+}
+
+// Evaluate the expression
+func (e *SafeNavExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	t, err := env.evaluate(e.target)
+	if err != nil {
+		return t, err
+	}
+	if isNull(t) {
+		return env.Null(), nil
+	}
+	return env.evaluateKnown(e.access, e.target, t)
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *SafeNavExpr) Literal() string {
+	return fmt.Sprintf("(%s != null ? %s : null)", e.target.Literal(), e.access.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *SafeNavExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *SafeNavExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
 // InExpr is a expression for list definitions
 type InExpr struct {
+	srcSpan
 	left  Expression
 	right Expression
 }
@@ -475,11 +1376,14 @@ func NewInExpr(left Expression, right Expression) *InExpr {
 
 // Evaluate the expression
 func (e *InExpr) Evaluate(env *Environment) (Expression, error) {
-	val, err := e.left.Evaluate(env)
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	val, err := env.evaluate(e.left)
 	if err != nil {
 		return val, err
 	}
-	list, err := e.right.Evaluate(env)
+	list, err := env.evaluate(e.right)
 	if err != nil {
 		return list, err
 	}
@@ -515,6 +1419,7 @@ func (e *InExpr) String() string {
 
 // LikeExpr is a basic binary expression(&&)
 type LikeExpr struct {
+	srcSpan
 	left  Expression
 	right Expression
 }
@@ -529,11 +1434,14 @@ func NewLikeExpr(left Expression, right Expression) *LikeExpr {
 
 // Evaluate the expression
 func (e *LikeExpr) Evaluate(env *Environment) (Expression, error) {
-	l, err := e.left.Evaluate(env)
+	if env.run == nil {
+		return env.evaluate(e)
+	}
+	l, err := env.evaluate(e.left)
 	if err != nil {
 		return l, err
 	}
-	r, err := e.right.Evaluate(env)
+	r, err := env.evaluate(e.right)
 	if err != nil {
 		return r, err
 	}
@@ -599,6 +1507,7 @@ func (e *LikeExpr) String() string {
 // FuncCallExpr is a expression holding a function and its arguments
 // Fantastic stuff
 type FuncCallExpr struct {
+	srcSpan
 	function Expression
 	args     []Expression
 }
@@ -632,23 +1541,36 @@ func (e *FuncCallExpr) GetArgs() []Expression {
 
 // Evaluate the expression
 func (e *FuncCallExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		// The call stack belongs to an evaluation
+		return env.evaluate(e)
+	}
 	args := make([]Expression, 0, len(e.GetArgs()))
-	f, err := e.GetFunc().Evaluate(env)
+	f, err := env.evaluate(e.GetFunc())
 	if err != nil {
 		return f, err
 	}
 	fun, ok := f.(Function)
 	if ok {
 		for _, aex := range e.GetArgs() {
-			a, err := aex.Evaluate(env)
+			a, err := env.evaluate(aex)
 			if err != nil {
 				return a, err
 			}
 			args = append(args, a)
 		}
-		env.pushStack(exFrame{function: fun, args: e.GetArgs()})
+		if err := env.check(); err != nil {
+			return env.Null(), err
+		}
+		if err := env.pushStack(exFrame{function: fun, args: e.GetArgs()}); err != nil {
+			return env.Null(), err
+		}
 		defer env.popStack()
-		return fun.Invoke(env, args)
+		res, err := fun.Invoke(env, args)
+		if err != nil {
+			return res, err
+		}
+		return res, env.checkSize(res)
 
 	}
 
@@ -676,6 +1598,7 @@ func (e *FuncCallExpr) String() string {
 // ScopedFuncCallExpr is a expression holding a scoped function and its arguments
 // Fantastic stuff
 type ScopedFuncCallExpr struct {
+	srcSpan
 	name  string
 	scope Expression
 	args  []Expression
@@ -708,31 +1631,44 @@ func (e *ScopedFuncCallExpr) GetArgs() []Expression {
 
 // Evaluate the expression
 func (e *ScopedFuncCallExpr) Evaluate(env *Environment) (Expression, error) {
+	if env.run == nil {
+		// The call stack belongs to an evaluation
+		return env.evaluate(e)
+	}
 
 	args := make([]Expression, len(e.GetArgs()))
 
-	f, err := env.Get(e.Literal()).Evaluate(env)
+	f, err := env.evaluate(env.Get(e.Literal()))
 
 	if err != nil {
 		return f, err
 	}
 	fun, ok := f.(*ScopedNativeFunctionExpr)
 	if ok {
-		evscope, scerr := e.scope.Evaluate(env)
+		evscope, scerr := env.evaluate(e.scope)
 		if scerr != nil {
 			return f, scerr
 		}
 		args = append(args, evscope)
 		for _, aex := range e.GetArgs() {
-			a, err := aex.Evaluate(env)
+			a, err := env.evaluate(aex)
 			if err != nil {
 				return a, err
 			}
 			args = append(args, a)
 		}
-		env.pushStack(exFrame{function: fun, args: e.GetArgs()})
+		if err := env.check(); err != nil {
+			return env.Null(), err
+		}
+		if err := env.pushStack(exFrame{function: fun, args: e.GetArgs()}); err != nil {
+			return env.Null(), err
+		}
 		defer env.popStack()
-		return fun.Invoke(env, args)
+		res, err := fun.Invoke(env, args)
+		if err != nil {
+			return res, err
+		}
+		return res, env.checkSize(res)
 
 	}
 
@@ -847,3 +1783,99 @@ func (e *ScopedNativeFunctionExpr) Value() interface{} {
 func (e *ScopedNativeFunctionExpr) String() string {
 	return fmt.Sprintf("%T", e)
 }
+
+//=============================================================================
+
+// LambdaExpr is a lambda expression on the form x => body or (a, b) => body.
+// The lambda evaluates to a LambdaFunctionExpr capturing the environment the lambda is evaluated in
+type LambdaExpr struct {
+	srcSpan
+	params []string
+	body   Expression
+}
+
+// NewLambdaExpr registers a new lambda expression with the parameter names and the body
+func NewLambdaExpr(params []string, body Expression) *LambdaExpr {
+	e := LambdaExpr{}
+	e.params = params
+	e.body = body
+	return &e
+}
+
+// Evaluate the expression
+func (e *LambdaExpr) Evaluate(env *Environment) (Expression, error) {
+	for _, p := range e.params {
+		if err := env.checkBinding(p); err != nil {
+			return env.Null(), err
+		}
+	}
+	return NewLambdaFunctionExpr(e, env), nil
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *LambdaExpr) Literal() string {
+	return fmt.Sprintf("((%s) => %s)", strings.Join(e.params, ", "), e.body.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *LambdaExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *LambdaExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
+
+//=============================================================================
+
+// LambdaFunctionExpr is the function of an evaluated lambda expression.
+// The body is evaluated in the captured environment, with the parameters bound to the arguments
+type LambdaFunctionExpr struct {
+	lambda *LambdaExpr
+	env    *Environment
+}
+
+// NewLambdaFunctionExpr registers a new function of the lambda, capturing the environment env
+func NewLambdaFunctionExpr(lambda *LambdaExpr, env *Environment) *LambdaFunctionExpr {
+	e := LambdaFunctionExpr{}
+	e.lambda = lambda
+	e.env = env
+	return &e
+}
+
+// Invoke for implementation of function interface
+func (e *LambdaFunctionExpr) Invoke(env *Environment, args []Expression) (Expression, error) {
+	if len(args) != len(e.lambda.params) {
+		return env.Null(), fmt.Errorf("lambda: expected %d arguments, got %d", len(e.lambda.params), len(args))
+	}
+	locals := e.env.locals
+	for i, p := range e.lambda.params {
+		// Parameters shadow the symbols of the captured environment
+		locals = &binding{name: p, expr: args[i], bound: true, next: locals}
+	}
+	scope := e.env.withLocals(locals)
+	// The body is evaluated as part of the calling evaluation
+	scope.run = env.run
+	return scope.evaluate(e.lambda.body)
+}
+
+// Evaluate the expression
+func (e *LambdaFunctionExpr) Evaluate(env *Environment) (Expression, error) {
+	return e, nil
+}
+
+// Literal will provide a uniqe literal for the expression
+func (e *LambdaFunctionExpr) Literal() string {
+	return fmt.Sprintf("(#lambda-function:%s#)", e.lambda.Literal())
+}
+
+// Value will provide value after evaluation
+func (e *LambdaFunctionExpr) Value() interface{} {
+	return fmt.Sprintf("[:%T:]", e)
+}
+
+// String will provide the string representation of value
+func (e *LambdaFunctionExpr) String() string {
+	return fmt.Sprintf("%T", e)
+}
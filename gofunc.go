@@ -0,0 +1,229 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+var (
+	envType = reflect.TypeOf((*Environment)(nil))
+	ctxType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterGoFunc registers a go function in the Environment.
+// Unlike RegisterFunction, the function is a plain go function like func(a, b int64) (int64, error).
+// The number of arguments are checked and the script values are converted to the go parameter types when the function is called:
+//   - numbers are converted to any numeric type that can hold the value
+//   - strings and booleans to their go counterpart
+//   - lists to slices, maps to maps with string keys
+//   - Expression parameters receives the expression as is
+//   - interface{} parameters receives the go value: scalars as is, lists as []interface{} and maps as map[string]interface{}
+//   - values bound to the environment (see Bind) are passed back as their go value
+//
+// Variadic functions are supported. Leading parameters of type *Environment and context.Context receives
+// the Environment and the context of the evaluation, see EvaluateContext.
+// The function may return nothing, a value, an error or a value and an error. The value is converted as in Bind().
+func (e *Environment) RegisterGoFunc(name string, fn interface{}) error {
+	cb, err := goFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	return e.Set(name, NewNativeFunctionExpr(name, cb))
+}
+
+// goFunc creates a NativeCallBack calling the go function by reflection
+func goFunc(name string, fn interface{}) (NativeCallBack, error) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return nil, fmt.Errorf("%s: not a function: %T", name, fn)
+	}
+	ft := f.Type()
+	switch {
+	case ft.NumOut() > 2:
+		return nil, fmt.Errorf("%s: function must return at most a value and an error: %s", name, ft)
+	case ft.NumOut() == 2 && ft.Out(1) != errType:
+		return nil, fmt.Errorf("%s: second return value must be an error: %s", name, ft)
+	}
+	first := 0
+	for first < ft.NumIn() && (ft.In(first) == envType || ft.In(first) == ctxType) {
+		first++
+	}
+	params := ft.NumIn() - first
+
+	return func(env *Environment, args []Expression) (Expression, error) {
+		if ft.IsVariadic() && len(args) < params-1 {
+			return env.Null(), fmt.Errorf("%s: expected at least %d arguments, got %d", name, params-1, len(args))
+		}
+		if !ft.IsVariadic() && len(args) != params {
+			return env.Null(), fmt.Errorf("%s: expected %d arguments, got %d", name, params, len(args))
+		}
+		in := make([]reflect.Value, 0, first+len(args))
+		for i := 0; i < first; i++ {
+			if ft.In(i) == envType {
+				in = append(in, reflect.ValueOf(env))
+			} else {
+				in = append(in, reflect.ValueOf(env.Context()))
+			}
+		}
+		for i, a := range args {
+			var pt reflect.Type
+			if ft.IsVariadic() && i >= params-1 {
+				pt = ft.In(ft.NumIn() - 1).Elem()
+			} else {
+				pt = ft.In(first + i)
+			}
+			v, err := unwrapValue(a, pt)
+			if err != nil {
+				return env.Null(), fmt.Errorf("%s: argument %d: %s", name, i+1, err.Error())
+			}
+			in = append(in, v)
+		}
+		return goResult(env, f.Call(in))
+	}, nil
+}
+
+// goResult converts the return values of a go function to an expression and an error
+func goResult(env *Environment, out []reflect.Value) (Expression, error) {
+	if len(out) == 0 {
+		return env.Null(), nil
+	}
+	last := out[len(out)-1]
+	if last.Type() == errType {
+		if !last.IsNil() {
+			return env.Null(), last.Interface().(error)
+		}
+		if len(out) == 1 {
+			return env.Null(), nil
+		}
+	}
+	res := wrapValue(out[0])
+	if res.Value() == nil {
+		return env.Null(), nil
+	}
+	return res, nil
+}
+
+// unwrapValue converts an evaluated expression to a go value of type t
+func unwrapValue(ex Expression, t reflect.Type) (reflect.Value, error) {
+	if ex == nil {
+		ex = NewScalarExprV(nil)
+	}
+	// Every expression is assignable to interface{}, which receives the go value below
+	if (t.Kind() != reflect.Interface || t.NumMethod() > 0) && reflect.TypeOf(ex).AssignableTo(t) {
+		return reflect.ValueOf(ex), nil
+	}
+	v := ex.Value()
+	if v == nil {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("cannot use null as %s", t)
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := v.(bool); ok {
+			return reflect.ValueOf(b).Convert(t), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := toInt64(v); ok {
+			if reflect.Zero(t).OverflowInt(i) {
+				return reflect.Value{}, fmt.Errorf("value %d overflows %s", i, t)
+			}
+			return reflect.ValueOf(i).Convert(t), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		p, k := promote(v)
+		if k == signedNum && p.(int64) >= 0 {
+			p, k = uint64(p.(int64)), unsignedNum
+		}
+		if k == unsignedNum {
+			if reflect.Zero(t).OverflowUint(p.(uint64)) {
+				return reflect.Value{}, fmt.Errorf("value %d overflows %s", p, t)
+			}
+			return reflect.ValueOf(p).Convert(t), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := toFloat64(v); ok {
+			return reflect.ValueOf(f).Convert(t), nil
+		}
+	case reflect.String:
+		if s, ok := v.(string); ok {
+			return reflect.ValueOf(s).Convert(t), nil
+		}
+	case reflect.Slice:
+		if list, ok := ex.(*ListExpr); ok {
+			res := reflect.MakeSlice(t, list.Count(), list.Count())
+			for i, le := range list.exprs {
+				ev, err := unwrapValue(le, t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("element %d: %s", i, err.Error())
+				}
+				res.Index(i).Set(ev)
+			}
+			return res, nil
+		}
+	case reflect.Map:
+		if m, ok := ex.(*MapExpr); ok && t.Key().Kind() == reflect.String {
+			res := reflect.MakeMapWithSize(t, m.Count())
+			for _, k := range m.keys {
+				ev, err := unwrapValue(m.exprs[k], t.Elem())
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("key %s: %s", k, err.Error())
+				}
+				res.SetMapIndex(reflect.ValueOf(k).Convert(t.Key()), ev)
+			}
+			return res, nil
+		}
+	case reflect.Interface:
+		if gv := goValue(ex); gv != nil && reflect.TypeOf(gv).AssignableTo(t) {
+			return reflect.ValueOf(gv), nil
+		}
+	}
+	// Values bound to the environment are passed back as is
+	if o, ok := ex.(*ObjectExpr); ok {
+		if o.value.Type().AssignableTo(t) {
+			return o.value, nil
+		}
+		if o.value.CanAddr() && o.value.Addr().Type().AssignableTo(t) {
+			return o.value.Addr(), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("cannot use %s as %s", typeName(ex), t)
+}
+
+// goValue returns the go value of an evaluated expression. Lists are converted to []interface{} and maps to map[string]interface{}
+func goValue(ex Expression) interface{} {
+	switch v := ex.(type) {
+	case *ListExpr:
+		res := make([]interface{}, 0, v.Count())
+		for _, le := range v.exprs {
+			res = append(res, goValue(le))
+		}
+		return res
+	case *MapExpr:
+		res := make(map[string]interface{}, v.Count())
+		for _, k := range v.keys {
+			res[k] = goValue(v.exprs[k])
+		}
+		return res
+	default:
+		return ex.Value()
+	}
+}
+
+// typeName returns the type name of an expression used in error messages
+func typeName(ex Expression) string {
+	switch ex.(type) {
+	case *ListExpr:
+		return "list"
+	case *MapExpr:
+		return "map"
+	case Function:
+		return "function"
+	default:
+		return fmt.Sprintf("%T", ex.Value())
+	}
+}
@@ -0,0 +1,121 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGoFunc(t *testing.T) {
+	t.Run("Convert", func(t *testing.T) { RunGoFuncConvertTest(t) })
+	t.Run("Errors", func(t *testing.T) { RunGoFuncErrorTest(t) })
+	t.Run("Register", func(t *testing.T) { RunGoFuncRegisterTest(t) })
+}
+
+func newGoFuncEnvironment(t *testing.T) *Environment {
+	env := NewEnvironment()
+	funcs := map[string]interface{}{
+		"multiply": func(a, b int64) (int64, error) { return a * b, nil },
+		"half":     func(f float32) float32 { return f / 2 },
+		"small":    func(i int8) int8 { return i },
+		"unsigned": func(u uint) uint { return u },
+		"upper":    strings.ToUpper,
+		"not":      func(b bool) bool { return !b },
+		"sum": func(values ...int) int {
+			s := 0
+			for _, v := range values {
+				s += v
+			}
+			return s
+		},
+		"join":  func(sep string, parts []string) string { return strings.Join(parts, sep) },
+		"keys":  func(m map[string]int) int { return len(m) },
+		"raw":   func(ex Expression) string { return ex.Literal() },
+		"any":   func(v interface{}) interface{} { return v },
+		"kind":  func(v interface{}) string { return fmt.Sprintf("%T", v) },
+		"env":   func(env *Environment, name string) Expression { return env.Get(name) },
+		"fail":  func() error { return errors.New("failed") },
+		"nop":   func() {},
+		"pair":  func() []string { return []string{"a", "b"} },
+		"count": func(o *testOrder) int { return len(o.Lines) },
+	}
+	for name, fn := range funcs {
+		if err := env.RegisterGoFunc(name, fn); err != nil {
+			t.Fatalf("RegisterGoFunc failed: %s", err.Error())
+		}
+	}
+	env.Bind("order", newTestOrder())
+	return env
+}
+
+func RunGoFuncConvertTest(t *testing.T) {
+	env := newGoFuncEnvironment(t)
+	RunExprEnvTest(t, env, "multiply(9, 10) == 90", true)
+	RunExprEnvTest(t, env, "half(3)", float64(1.5))
+	RunExprEnvTest(t, env, "small(-7)", int64(-7))
+	RunExprEnvTest(t, env, "unsigned(7)", uint64(7))
+	RunExprEnvTest(t, env, "upper('expr')", "EXPR")
+	RunExprEnvTest(t, env, "not(false)", true)
+	RunExprEnvTest(t, env, "sum()", int64(0))
+	RunExprEnvTest(t, env, "sum(1, 2, 3)", int64(6))
+	RunExprEnvTest(t, env, "join('-', ['a', 'b'])", "a-b")
+	RunExprEnvTest(t, env, "keys({a: 1, b: 2})", int64(2))
+	RunExprEnvTest(t, env, "raw(1 + 1)", "2")
+	RunExprEnvTest(t, env, "any('x')", "x")
+	RunExprEnvTest(t, env, "any([1, 2])[1]", int64(2))
+	// interface{} parameters receives the go value, not the expression
+	RunExprEnvTest(t, env, "kind('x')", "string")
+	RunExprEnvTest(t, env, "kind(1)", "int64")
+	RunExprEnvTest(t, env, "kind([1, 2])", "[]interface {}")
+	RunExprEnvTest(t, env, "kind({a: 1})", "map[string]interface {}")
+	RunExprEnvTest(t, env, "kind(null)", "<nil>")
+	RunExprEnvTest(t, env, "kind(order)", "expr.testOrder")
+	RunExprEnvTest(t, env, "env('true')", true)
+	RunExprEnvTest(t, env, "nop()", nil)
+	RunExprEnvTest(t, env, "pair()[1]", "b")
+	RunExprEnvTest(t, env, "count(order)", int64(2))
+}
+
+func RunGoFuncErrorTest(t *testing.T) {
+	env := newGoFuncEnvironment(t)
+	tests := map[string]string{
+		"multiply(1)":        "multiply: expected 2 arguments, got 1",
+		"multiply(1, 'a')":   "multiply: argument 2: cannot use string as int64",
+		"multiply(1.5, 2)":   "multiply: argument 1: cannot use float64 as int64",
+		"small(300)":         "small: argument 1: value 300 overflows int8",
+		"unsigned(-1)":       "unsigned: argument 1: cannot use int64 as uint",
+		"join('-', [1])":     "join: argument 2: element 0: cannot use int64 as string",
+		"join('-', 'a')":     "join: argument 2: cannot use string as []string",
+		"not(null)":          "not: argument 1: cannot use null as bool",
+		"fail()":             "failed",
+		"count({lines: []})": "count: argument 1: cannot use map as *expr.testOrder",
+	}
+	for script, msg := range tests {
+		ex, err := env.GetParser().Parse(script)
+		if err != nil {
+			t.Errorf("Parse failed: %s. Error: %s\n", script, err.Error())
+			continue
+		}
+		_, err = ex.Evaluate(env)
+		if err != nil {
+			err = errors.Unwrap(err)
+		}
+		if err == nil || err.Error() != msg {
+			t.Errorf("Unexpected error: %s. Error: %v (expected %s)\n", script, err, msg)
+		}
+	}
+}
+
+func RunGoFuncRegisterTest(t *testing.T) {
+	env := NewEnvironment()
+	if err := env.RegisterGoFunc("x", 1); err == nil {
+		t.Errorf("RegisterGoFunc should fail for non functions")
+	}
+	if err := env.RegisterGoFunc("x", func() (int, int) { return 1, 1 }); err == nil {
+		t.Errorf("RegisterGoFunc should fail when second return value is not an error")
+	}
+	if err := env.RegisterGoFunc("x", func() (int, int, error) { return 1, 1, nil }); err == nil {
+		t.Errorf("RegisterGoFunc should fail for more than two return values")
+	}
+}
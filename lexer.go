@@ -1,9 +1,12 @@
 package expr
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -15,44 +18,67 @@ type stateFunc func(*lexer) stateFunc
 
 // lexer functions similarly to Rob Pike's discussion
 // about lexer design in this [talk](https://www.youtube.com/watch?v=HxaD_trXwRE).
+// The lexer is pulled by the parser: NextToken runs the state functions until a token is emitted.
 type lexer struct {
-	input      string
-	start      int
-	pos        int
-	width      int
-	line       int
-	includeWS  bool
-	doubleOps  map[string]bool
-	tokens     chan Token
-	tokenStack tokenStack
+	input     string
+	start     int
+	pos       int
+	width     int
+	startLine int // line of start
+	startCol  int // column of start
+	includeWS bool
+	state     stateFunc
+	// tokens emitted by the state functions and not yet returned, from tokens[head]
+	tokens []Token
+	head   int
+	// tokens pushed back by the parser, last in first out
+	pushed []Token
+	// recovering is set when the parser recovers from syntax errors, see Parser.ParseAll
+	recovering  bool
+	diagnostics []*SyntaxError
+}
+
+// Tokenize returns the tokens of the input, using the same lexer as the parser.
+// The last token is of type EoFTok. Whitespace and comment tokens are included when includeWhitespace is set.
+// Invalid input returns the tokens up to the invalid token and a *SyntaxError
+func Tokenize(input string, includeWhitespace bool) ([]Token, error) {
+	l := newLexer(includeWhitespace, input)
+	var tokens []Token
+	for {
+		t, _ := l.NextToken()
+		if t.Type == ErrorTok {
+			return tokens, l.errorAt(t, "%v", t.Value)
+		}
+		tokens = append(tokens, t)
+		if t.Type == EoFTok {
+			return tokens, nil
+		}
+	}
 }
 
 // newLexer creates and returns a lexer ready to parse the given input
 func newLexer(includeWS bool, in string) *lexer {
 	l := lexer{
-		input:      in,
-		start:      0,
-		pos:        0,
-		width:      0,
-		line:       0,
-		includeWS:  includeWS,
-		doubleOps:  registerDoubleOps(),
-		tokens:     make(chan Token),
-		tokenStack: newTokenStack(),
-	}
-	go l.run()
+		input:     in,
+		includeWS: includeWS,
+		state:     lexInput,
+		tokens:    make([]Token, 0, 2),
+	}
 	return &l
 }
 
-func registerDoubleOps() map[string]bool {
-	ops := make(map[string]bool)
-	ops["=="] = true
-	ops["!="] = true
-	ops["<="] = true
-	ops[">="] = true
-	ops["||"] = true
-	ops["&&"] = true
-	return ops
+// doubleOps are the operators of two characters
+var doubleOps = map[string]bool{
+	"==": true,
+	"!=": true,
+	"<=": true,
+	">=": true,
+	"||": true,
+	"&&": true,
+	"**": true,
+	"??": true,
+	"?.": true,
+	"=>": true,
 }
 
 // next pulls the next rune from the lexer and returns it, moving the position
@@ -62,86 +88,89 @@ func (l *lexer) next() rune {
 		l.width = 0
 		return eof
 	}
-	r, w := utf8.DecodeRuneInString(l.input[l.pos:])
+	r, w := rune(l.input[l.pos]), 1
+	if r >= utf8.RuneSelf {
+		r, w = utf8.DecodeRuneInString(l.input[l.pos:])
+	}
 	l.width = w
 	l.pos += l.width
-	if r == '\n' {
-		l.line++
-	}
 	return r
 }
 
 // peek returns but does not consume the next rune in the input.
-//
-//lint:ignore U1000 Ignore unused function temporarily for debugging
-func (l lexer) peek() rune {
+// The width of the rune consumed last is kept, so backup can still be called after peek
+func (l *lexer) peek() rune {
+	w := l.width
 	r := l.next()
 	l.backup()
+	l.width = w
 	return r
 }
 
+// afterOperand reports whether the current token immediately follows an identifier or a closing bracket,
+// making a following '.' a member access like x.y rather than the start of a number like .5
+func (l *lexer) afterOperand() bool {
+	r, _ := utf8.DecodeLastRuneInString(l.input[:l.start])
+	return isIdentBody(r) || strings.ContainsRune(")]}", r)
+}
+
 // backup steps back one rune. Can only be called once per call of next.
 func (l *lexer) backup() {
 	l.pos -= l.width
-	// Correct newline count.
-	if l.width == 1 && l.input[l.pos] == '\n' {
-		l.line--
-	}
 }
 
 // Current returns the value being analyzed at this moment.
-func (l lexer) Current() string {
+func (l *lexer) Current() string {
 	return l.input[l.start:l.pos]
 }
 
 // Emit will receive a token and push a new token with the current analyzed
-// value into the tokens channel.
+// value to the tokens to be returned by NextToken.
 func (l *lexer) emit(tok Token, send bool) {
 	if send {
-		l.tokens <- tok
+		l.tokens = append(l.tokens, tok)
 	}
+	// The next token starts at the current position
+	l.startLine, l.startCol = advance(l.startLine, l.startCol, l.input[l.start:l.pos])
 	l.start = l.pos
 }
 
 // NextToken returns the next token from the lexer and a value to denote whether
 // or not the lexer is finished.
 func (l *lexer) NextToken() (token Token, finished bool) {
-	if st := l.tokenStack.pop(); st != nil {
-		return *st, false
+	if n := len(l.pushed); n > 0 {
+		token = l.pushed[n-1]
+		l.pushed = l.pushed[:n-1]
+		return token, false
 	}
-	if tok, ok := <-l.tokens; ok {
-		return tok, false
+	for l.head == len(l.tokens) {
+		if l.state == nil {
+			return Token{Type: EoFTok, Line: l.startLine, Column: l.startCol, Start: l.start}, true
+		}
+		// Reuse the buffer when every token is returned
+		l.tokens = l.tokens[:0]
+		l.head = 0
+		l.state = l.state(l)
 	}
-	return Token{}, true
-
+	token = l.tokens[l.head]
+	l.head++
+	return token, false
 }
 
-// PushBack returns the next token from the lexer and a value to denote whether
-// or not the lexer is finished.
+// PushBack returns the token to the lexer, to be returned by the next call to NextToken.
 func (l *lexer) PushBack(t Token) {
-	l.tokenStack.push(t)
-}
-
-// Private methods
-func (l *lexer) run() {
-	for state := lexInput; state != nil; {
-		state = state(l)
-	}
-	close(l.tokens)
+	l.pushed = append(l.pushed, t)
 }
 
 // eat receives a string containing all acceptable strings and will contine
 // over each consecutive character in the source until a token not in the given
 // string is encountered. This should be used to quickly pull token parts.
 func (l *lexer) eat(chars string) string {
-	result := ""
-	r := l.next()
-	for strings.ContainsRune(chars, r) {
-		result += string(r)
-		r = l.next()
+	start := l.pos
+	for strings.ContainsRune(chars, l.next()) {
 	}
 	l.backup() // last next wasn't a match
-	return result
+	return l.input[start:l.pos]
 }
 
 // accept consumes the next rune if it's from the valid set.
@@ -162,9 +191,16 @@ const chDIGIT = "0123456789"
 const chBinary = "01"
 const chOCTAL = "01234567"
 const chHEXDIGIT = chDIGIT + "abcdefABCDEF"
-const chALPHA = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-const chIDENTSTART = chALPHA + "_"
-const chIDENTBODY = chIDENTSTART + chDIGIT
+
+// isIdentStart reports whether the rune can start an identifier, a unicode letter or '_' as in go
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// isIdentBody reports whether the rune can be part of an identifier, a unicode letter, digit or '_' as in go
+func isIdentBody(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
 
 // lexInput starts the lexing of the text input string
 func lexInput(l *lexer) stateFunc {
@@ -172,16 +208,23 @@ func lexInput(l *lexer) stateFunc {
 	r := l.next()
 	if strings.ContainsRune(chWHITE, r) {
 		return lexSpace(l)
-	} else if strings.ContainsRune(chIDENTSTART, r) {
+	} else if isIdentStart(r) {
 		return lexIdent(l)
-	} else if strings.ContainsRune(chDIGIT, r) {
+	} else if r == '@' && strings.ContainsRune("'\"`", l.peek()) {
+		return lexQuotedIdent(l)
+	} else if strings.ContainsRune(chDIGIT, r) || (r == '.' && strings.ContainsRune(chDIGIT, l.peek()) && !l.afterOperand()) {
 		l.backup()
 		return lexNumber(l)
 	} else if r == '"' || r == '\'' {
 		l.backup()
 		return lexQuoted(l)
+	} else if r == '`' {
+		l.backup()
+		return lexRaw(l)
+	} else if r == '/' && (l.peek() == '/' || l.peek() == '*') {
+		return lexComment(l)
 	} else if r == eof {
-		l.emit(Token{Type: EoFTok}, true)
+		l.emit(Token{Type: EoFTok, Line: l.startLine, Column: l.startCol, Start: l.pos}, true)
 		return nil
 	}
 	l.backup()
@@ -194,139 +237,471 @@ func lexSpace(l *lexer) stateFunc {
 	tok := Token{
 		Type:    WhitespaceTok,
 		Literal: l.Current(),
-		Line:    l.line,
+		Line:    l.startLine,
+		Column:  l.startCol,
+		Start:   l.start,
+	}
+	l.emit(tok, l.includeWS)
+	return lexInput
+}
+
+// lexComment scans a line comment up to the end of the line, or a block comment up to and including */.
+// The leading '/' is already consumed
+func lexComment(l *lexer) stateFunc {
+	if l.next() == '/' {
+		for r := l.next(); r != '\n' && r != eof; r = l.next() {
+		}
+		// The newline is whitespace
+		l.backup()
+	} else {
+		for r := l.next(); !(r == '*' && l.peek() == '/'); r = l.next() {
+			if r == eof {
+				return l.errorf("unterminated block comment")
+			}
+		}
+		l.next()
+	}
+	tok := Token{
+		Type:    CommentTok,
+		Literal: l.Current(),
+		Line:    l.startLine,
+		Column:  l.startCol,
 		Start:   l.start,
 	}
 	l.emit(tok, l.includeWS)
-	return lexInput(l)
+	return lexInput
 }
 
 // lexIdent scans all ident body characters.
 func lexIdent(l *lexer) stateFunc {
-	l.eat(chIDENTBODY)
+	for isIdentBody(l.next()) {
+	}
+	l.backup()
 	tok := Token{
 		Type:    IdentTok,
 		Literal: l.Current(),
-		Line:    l.line,
+		Line:    l.startLine,
+		Column:  l.startCol,
 		Start:   l.start,
 	}
 	l.emit(tok, true)
-	return lexInput(l)
+	return lexInput
 }
 
-// lexIdent scans all ident body characters.
+// lexQuotedIdent scans a quoted identifier, '@' followed by a quoted or raw string like @`content-type`.
+// The leading '@' is already consumed. The Value of the identifier token is the name
+func lexQuotedIdent(l *lexer) stateFunc {
+	n := len(l.tokens)
+	var state stateFunc
+	if l.peek() == '`' {
+		state = lexRaw(l)
+	} else {
+		state = lexQuoted(l)
+	}
+	if len(l.tokens) == n {
+		return state
+	}
+	tok := &l.tokens[len(l.tokens)-1]
+	switch {
+	case tok.Type == TemplateTok:
+		*tok = Token{Type: ErrorTok, Literal: "ERROR", Value: "quoted identifier cannot be interpolated", Line: tok.Line, Column: tok.Column, Start: tok.Start}
+		return nil
+	case tok.Type == StringTok && tok.Value == "":
+		*tok = Token{Type: ErrorTok, Literal: "ERROR", Value: "empty quoted identifier", Line: tok.Line, Column: tok.Column, Start: tok.Start}
+		return nil
+	case tok.Type == StringTok:
+		tok.Type = IdentTok
+	}
+	return state
+}
+
+// lexNumber scans an integer or floating point number.
 func lexNumber(l *lexer) stateFunc {
-	fl, err := l.scanNumber()
+	num, err := l.scanNumber()
 	if err != nil {
-		return l.errorf("bad number syntax: %q. error: %q", l.Current(), err.Error())
+		return l.errorf("%s", err.Error())
 	}
 	tok := Token{
 		Type:    NumberTok,
 		Literal: l.Current(),
-		Line:    l.line,
+		Line:    l.startLine,
+		Column:  l.startCol,
 		Start:   l.start,
-		Value:   fl,
+		Value:   num,
 	}
 	l.emit(tok, true)
-	return lexInput(l)
+	return lexInput
 }
+
+// scanNumber scans the number and returns its value. Supported forms are 42, 0x2A, 0o52, 0b101010,
+// 1.5, .5, 1e6 and 2.5E-3, with digits optionally separated by underscores like 1_000_000.
+// Integers are int64, or uint64 when they are too large for int64. Larger integers are an error
 func (l *lexer) scanNumber() (interface{}, error) {
-	// Optional leading sign.
-	//l.accept("+-")
-	digits := chDIGIT
 	// Is it hex,octal,binary?
 	if l.accept("0") {
 		// Note: Leading 0 does not mean octal in floats.
+		base, digits := 0, ""
 		if l.accept("xX") {
-			digits = chHEXDIGIT
-			l.eat(digits)
-			return strconv.ParseInt(l.Current(), 16, 64)
+			base, digits = 16, chHEXDIGIT
 		} else if l.accept("oO") {
-			digits = chOCTAL
-			l.eat(digits)
-			return strconv.ParseInt(l.Current(), 8, 64)
+			base, digits = 8, chOCTAL
 		} else if l.accept("bB") {
-			digits = chBinary
-			l.eat(digits)
-			return strconv.ParseInt(l.Current(), 2, 64)
+			base, digits = 2, chBinary
+		}
+		if base != 0 {
+			// Go allows a separator between the prefix and the digits, 0x_FF
+			text := l.eat(digits + "_")
+			if text == "" || !separated("0"+text) {
+				return nil, fmt.Errorf("bad number syntax: %q", l.Current())
+			}
+			return l.parseInteger(text, base)
 		}
 	}
-	l.eat(digits)
+	isFloat := false
+	parts := []string{l.input[l.start:l.pos] + l.eat(chDIGIT+"_")}
 	if l.accept(".") {
-		l.eat(digits)
-		return strconv.ParseFloat(l.Current(), 64)
+		isFloat = true
+		parts = append(parts, l.eat(chDIGIT+"_"))
+	}
+	if l.accept("eE") {
+		isFloat = true
+		l.accept("+-")
+		exp := l.eat(chDIGIT + "_")
+		if exp == "" {
+			return nil, fmt.Errorf("bad number syntax: %q, missing exponent", l.Current())
+		}
+		parts = append(parts, exp)
+	}
+	for _, p := range parts {
+		if !separated(p) {
+			return nil, fmt.Errorf("bad number syntax: %q, '_' must separate digits", l.Current())
+		}
 	}
-	return strconv.ParseInt(l.Current(), 10, 64)
+	if !isFloat {
+		return l.parseInteger(l.Current(), 10)
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(l.Current(), "_", ""), 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("number %s is out of range", l.Current())
+	} else if err != nil {
+		return nil, fmt.Errorf("bad number syntax: %q", l.Current())
+	}
+	return f, nil
 }
 
-// lexQuoted scans a quoted string.
+// parseInteger returns the digits of the current number as int64, or as uint64 if it does not fit in an int64
+func (l *lexer) parseInteger(digits string, base int) (interface{}, error) {
+	u, err := strconv.ParseUint(strings.ReplaceAll(digits, "_", ""), base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("integer %s overflows 64 bits", l.Current())
+	} else if err != nil {
+		return nil, fmt.Errorf("bad number syntax: %q", l.Current())
+	}
+	if u <= math.MaxInt64 {
+		return int64(u), nil
+	}
+	return u, nil
+}
+
+// separated reports whether all underscores in the digits are placed between two digits
+func separated(digits string) bool {
+	return !strings.HasPrefix(digits, "_") && !strings.HasSuffix(digits, "_") && !strings.Contains(digits, "__")
+}
+
+// lexQuoted scans a quoted string, decoding go escape sequences like \n, \t, \x41, \u00e9 and \'.
+// Both \' and \" are accepted in strings quoted by ' and ".
 func lexQuoted(l *lexer) stateFunc {
 	quote := l.next()
-	tok := Token{
-		Type: StringTok,
-	}
-	value := ""
-Loop:
+	var value strings.Builder
 	for {
 		c := l.next()
 		switch c {
-		case '\\':
-			if r := l.next(); r != eof && r != '\n' {
-				break
-			}
-			fallthrough
 		case eof, '\n':
 			return l.errorf("unterminated quoted string")
 		case quote:
-			break Loop
+			tok := Token{
+				Type:    StringTok,
+				Literal: l.Current(),
+				Value:   value.String(),
+				Line:    l.startLine,
+				Column:  l.startCol,
+				Start:   l.start,
+			}
+			l.emit(tok, true)
+			return lexInput
+		case '\\':
+			r := l.peek()
+			if r == eof || r == '\n' {
+				return l.errorf("unterminated quoted string")
+			}
+			if r == '\'' || r == '"' {
+				value.WriteRune(l.next())
+				continue
+			}
+			escStart := l.pos - 1
+			v, multibyte, tail, err := strconv.UnquoteChar(l.input[escStart:], byte(quote))
+			if err != nil {
+				// An invalid byte is one wide
+				_, w := utf8.DecodeRuneInString(l.input[escStart+1:])
+				return l.errorfAt(escStart, "invalid escape sequence: %s", l.input[escStart:escStart+1+w])
+			}
+			// \x and octal escapes are bytes
+			if v < utf8.RuneSelf || !multibyte {
+				value.WriteByte(byte(v))
+			} else {
+				value.WriteRune(v)
+			}
+			l.pos = len(l.input) - len(tail)
+		default:
+			value.WriteRune(c)
 		}
-		value += string(c)
 	}
-	tok.Literal = l.Current()
-	tok.Value = value
-	tok.Line = l.line
-	tok.Start = l.start
+}
+
+// lexRaw scans a raw string quoted by backticks. Raw strings may span lines and have no escape sequences.
+// Carriage returns are removed from the text, as in go.
+// Expressions embedded on the form ${expr} gives a TemplateTok, where $${ is the text ${
+func lexRaw(l *lexer) stateFunc {
+	l.next()
+	var parts []templatePart
+	// the text of the current part is prefix + input[text:], the part starts at start
+	prefix, text, start := "", l.pos, l.pos
+	for r := l.next(); r != '`'; r = l.next() {
+		switch {
+		case r == eof:
+			return l.errorf("unterminated raw string")
+		case r == '$' && strings.HasPrefix(l.input[l.pos:], "${"):
+			// $${ is the text ${, where the text continues after the second $
+			prefix += l.input[text:l.pos]
+			l.next()
+			text = l.pos
+		case r == '$' && l.peek() == '{':
+			parts = append(parts, templatePart{text: prefix + l.input[text:l.pos-1], start: start, end: l.pos - 1})
+			prefix = ""
+			open := l.pos - 1
+			l.next()
+			tokens, errTok := l.scanEmbedded()
+			if errTok != nil {
+				l.tokens = append(l.tokens, *errTok)
+				return nil
+			}
+			if tokens == nil {
+				return l.errorfAt(open, "unterminated interpolation")
+			}
+			parts = append(parts, templatePart{tokens: tokens})
+			text, start = l.pos, l.pos
+		}
+	}
+	parts = append(parts, templatePart{text: prefix + l.input[text:l.pos-1], start: start, end: l.pos - 1})
+	tok := Token{
+		Type:    StringTok,
+		Literal: l.Current(),
+		Line:    l.startLine,
+		Column:  l.startCol,
+		Start:   l.start,
+	}
+	if len(parts) == 1 {
+		tok.Value = parts[0].value()
+	} else {
+		tok.Type = TemplateTok
+		tok.Value = parts
+	}
 	l.emit(tok, true)
-	return lexInput(l)
+	return lexInput
+}
+
+// templatePart is a part of a TemplateTok, either a text or the tokens of an embedded expression
+type templatePart struct {
+	text   string
+	start  int // byte offset of the text in the input
+	end    int
+	tokens []Token
+}
+
+// value returns the text without carriage returns
+func (p templatePart) value() string {
+	return strings.ReplaceAll(p.text, "\r", "")
+}
+
+// scanEmbedded scans the tokens of an expression embedded in a raw string, following ${ and up to and including the closing }.
+// Returns nil if the input ends before the closing }, or the error token of invalid input
+func (l *lexer) scanEmbedded() ([]Token, *Token) {
+	line, col := advance(l.startLine, l.startCol, l.input[l.start:l.pos])
+	sub := lexer{
+		input:     l.input,
+		start:     l.pos,
+		pos:       l.pos,
+		startLine: line,
+		startCol:  col,
+		state:     lexInput,
+	}
+	var tokens []Token
+	depth := 0
+	for {
+		t, _ := sub.NextToken()
+		switch {
+		case t.Type == ErrorTok:
+			return nil, &t
+		case t.Type == EoFTok:
+			return nil, nil
+		case isOperator(t, "{"):
+			depth++
+		case isOperator(t, "}"):
+			if depth == 0 {
+				l.pos = sub.pos
+				return append(tokens, t), nil
+			}
+			depth--
+		}
+		tokens = append(tokens, t)
+	}
 }
 
 // lexOperator lexes both single and dounle operands
 func lexOperator(l *lexer) stateFunc {
 	tok := Token{
-		Type:  OperatorTok,
-		Line:  l.line,
-		Start: l.start,
-	}
-	c := l.next()
-	c2 := l.next()
-	tok.Literal = string(c) + string(c2)
-	if !l.doubleOp(tok.Literal) {
+		Type:   OperatorTok,
+		Line:   l.startLine,
+		Column: l.startCol,
+		Start:  l.start,
+	}
+	l.next()
+	l.next()
+	// a?.5:1 is a conditional with the number .5, not a safe navigation
+	if !l.doubleOp(l.Current()) || (l.Current() == "?." && strings.ContainsRune(chDIGIT, l.peek())) {
 		l.backup()
-		tok.Literal = string(c)
 	}
+	tok.Literal = l.Current()
 	l.emit(tok, true)
-	return lexInput(l)
+	return lexInput
 }
 
 func (l *lexer) doubleOp(literal string) bool {
-	_, ok := l.doubleOps[literal]
-	return ok
+	return doubleOps[literal]
 }
 
 // errorf returns an error token and terminates the scan by passing
 // back a nil pointer that will be the next state, terminating l.nextItem.
 func (l *lexer) errorf(format string, args ...interface{}) stateFunc {
+	return l.errorfAt(l.start, format, args...)
+}
+
+// errorfAt returns an error token at the byte offset pos within the current token, see errorf
+func (l *lexer) errorfAt(pos int, format string, args ...interface{}) stateFunc {
+	line, col := advance(l.startLine, l.startCol, l.input[l.start:pos])
 	tok := Token{
 		Type:    ErrorTok,
 		Literal: "ERROR",
 		Value:   fmt.Sprintf(format, args...),
-		Line:    l.line,
-		Start:   l.start,
+		Line:    line,
+		Column:  col,
+		Start:   pos,
 	}
 	l.emit(tok, true)
 	return nil
 }
 
+// advance returns the line and column following the text starting at line and col
+func advance(line int, col int, text string) (int, int) {
+	if i := strings.LastIndexByte(text, '\n'); i >= 0 {
+		return line + strings.Count(text, "\n"), utf8.RuneCountInString(text[i+1:])
+	}
+	return line, col + utf8.RuneCountInString(text)
+}
+
+// position returns the position of the token in the input
+func (l *lexer) position(t Token) Position {
+	return Position{
+		Offset: t.Start,
+		Line:   t.Line + 1,
+		Column: t.Column + 1,
+	}
+}
+
+// positionIn returns the position of the byte offset within the token t
+func (l *lexer) positionIn(t Token, offset int) Position {
+	line, col := advance(t.Line, t.Column, l.input[t.Start:offset])
+	return Position{Offset: offset, Line: line + 1, Column: col + 1}
+}
+
+// end returns the position following the token in the input
+func (l *lexer) end(t Token) Position {
+	line, col := advance(t.Line, t.Column, t.Literal)
+	return Position{Offset: t.Start + len(t.Literal), Line: line + 1, Column: col + 1}
+}
+
+// mark records the span from start to end on the expression and returns the expression
+func (l *lexer) mark(ex Expression, start Position, end Position) Expression {
+	if s, ok := ex.(interface{ setSpan(Span, string) }); ok {
+		s.setSpan(Span{Start: start, End: end}, l.input)
+	}
+	return ex
+}
+
+// markToken records the span of the token on the expression and returns the expression
+func (l *lexer) markToken(ex Expression, t Token) Expression {
+	return l.mark(ex, l.position(t), l.end(t))
+}
+
+// markBetween records the span from the start of the first expression to the end of the last expression
+// on the expression and returns the expression
+func (l *lexer) markBetween(ex Expression, first Expression, last Expression) Expression {
+	return l.mark(ex, spanOf(first).Start, spanOf(last).End)
+}
+
+// errorAt returns a SyntaxError for the token
+func (l *lexer) errorAt(t Token, format string, args ...interface{}) *SyntaxError {
+	p := l.position(t)
+	return &SyntaxError{
+		Position: p,
+		Token:    t,
+		Msg:      fmt.Sprintf(format, args...),
+		Snippet:  snippet(l.input, p),
+	}
+}
+
+// report records the syntax error when the parser recovers from errors. An error reported again by
+// the enclosing expressions is only recorded once. Returns false if the parser does not recover from
+// errors or the error is not a syntax error
+func (l *lexer) report(err error) (*SyntaxError, bool) {
+	var se *SyntaxError
+	if !l.recovering || !errors.As(err, &se) {
+		return nil, false
+	}
+	for _, d := range l.diagnostics {
+		if d.Offset == se.Offset {
+			return se, true
+		}
+	}
+	l.diagnostics = append(l.diagnostics, se)
+	return se, true
+}
+
+// recoverFrom reports the syntax error and skips the tokens from the offending token up to the next
+// ',', ')', ']' or '}' outside brackets, which is pushed back. Returns false if the error is not reported
+func (l *lexer) recoverFrom(err error) bool {
+	se, ok := l.report(err)
+	if !ok {
+		return false
+	}
+	depth := 0
+	for t := se.Token; ; t, _ = l.NextToken() {
+		if t.Type == EoFTok || (depth == 0 && syncToken(t)) {
+			l.PushBack(t)
+			return true
+		}
+		if t.Type != OperatorTok {
+			continue
+		}
+		switch t.Literal {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		}
+	}
+}
+
 //====================================================================================
 //Token below
 //====================================================================================
@@ -334,10 +709,11 @@ func (l *lexer) errorf(format string, args ...interface{}) stateFunc {
 // Token represents a lexical token.
 type Token struct {
 	Type    TokenType
-	Literal string
-	Value   interface{}
-	Line    int
-	Start   int
+	Literal string      // the token as written in the input
+	Value   interface{} // the value of strings and numbers, the message of errors
+	Line    int         // the line of the start of the token, starting at 0
+	Column  int         // the column of the start of the token in characters, starting at 0
+	Start   int         // the byte offset of the start of the token
 }
 
 // TokenType is the different tokentypes provided by
@@ -352,41 +728,31 @@ const (
 	StringTok
 	NumberTok
 	OperatorTok
+	CommentTok
+	TemplateTok
 )
 
-type tokenNode struct {
-	t    Token
-	next *tokenNode
-}
-
-type tokenStack struct {
-	start *tokenNode
-}
-
-func newTokenStack() tokenStack {
-	return tokenStack{}
-}
-
-func (s *tokenStack) push(t Token) {
-	node := &tokenNode{t: t}
-	if s.start == nil {
-		s.start = node
-	} else {
-		node.next = s.start
-		s.start = node
+// String returns the name of the token type
+func (tt TokenType) String() string {
+	switch tt {
+	case ErrorTok:
+		return "error"
+	case EoFTok:
+		return "end of input"
+	case WhitespaceTok:
+		return "whitespace"
+	case IdentTok:
+		return "identifier"
+	case StringTok:
+		return "string"
+	case NumberTok:
+		return "number"
+	case OperatorTok:
+		return "operator"
+	case CommentTok:
+		return "comment"
+	case TemplateTok:
+		return "template"
 	}
-}
-
-func (s *tokenStack) pop() *Token {
-	if s.start == nil {
-		return nil
-	}
-	n := s.start
-	s.start = n.next
-	return &n.t
-}
-
-//lint:ignore U1000 Ignore unused function temporarily for debugging
-func (s *tokenStack) clear() {
-	s.start = nil
+	return fmt.Sprintf("TokenType(%d)", int(tt))
 }
@@ -1,11 +1,23 @@
 package expr
 
 import (
+	"errors"
+	"fmt"
+	"runtime"
 	"testing"
 )
 
 func Test(t *testing.T) {
 	t.Run("QuotedString", func(t *testing.T) { RunLexerQuotedStringTest(t) })
+	t.Run("Tokens", func(t *testing.T) { RunLexerTokensTest(t) })
+	t.Run("Tokenize", func(t *testing.T) { RunTokenizeTest(t) })
+	t.Run("TokenizeError", func(t *testing.T) { RunTokenizeErrorTest(t) })
+	t.Run("Escapes", func(t *testing.T) { RunLexerEscapeTest(t) })
+	t.Run("RawStrings", func(t *testing.T) { RunLexerRawStringTest(t) })
+	t.Run("Comments", func(t *testing.T) { RunLexerCommentTest(t) })
+	t.Run("Numbers", func(t *testing.T) { RunLexerNumberTest(t) })
+	t.Run("Identifiers", func(t *testing.T) { RunLexerIdentTest(t) })
+	t.Run("NoGoroutine", func(t *testing.T) { RunLexerNoGoroutineTest(t) })
 }
 
 func RunLexerQuotedStringTest(t *testing.T) {
@@ -60,3 +72,325 @@ func RunLexerQuotedStringTest(t *testing.T) {
 	})
 
 }
+
+func RunLexerTokensTest(t *testing.T) {
+	l := newLexer(false, "a.b >= 1.5 ** 2 && 'x' != \"y\"")
+	expected := []string{"a", ".", "b", ">=", "1.5", "**", "2", "&&", "'x'", "!=", "\"y\""}
+	for i, literal := range expected {
+		tok, fini := l.NextToken()
+		if fini || tok.Literal != literal {
+			t.Fatalf("Unexpected token %d: %v, expected %s\n", i, tok, literal)
+		}
+		if i == 3 {
+			l.PushBack(tok)
+			if again, _ := l.NextToken(); again != tok {
+				t.Fatalf("Unexpected token after push back: %v, expected %v\n", again, tok)
+			}
+		}
+	}
+	if tok, fini := l.NextToken(); fini || tok.Type != EoFTok || tok.Start != 29 {
+		t.Errorf("Expected end of input: %v %v\n", tok, fini)
+	}
+	if tok, fini := l.NextToken(); !fini || tok.Type != EoFTok {
+		t.Errorf("Expected finished lexer: %v %v\n", tok, fini)
+	}
+
+	tokens, err := Tokenize("a?.b ?? c?.5:.5", false)
+	expected = []string{"a", "?.", "b", "??", "c", "?", ".5", ":", ".5", ""}
+	if err != nil || len(tokens) != len(expected) {
+		t.Fatalf("Unexpected tokens: %v %v\n", tokens, err)
+	}
+	for i, literal := range expected {
+		if tokens[i].Literal != literal {
+			t.Errorf("Unexpected token %d: %v, expected %s\n", i, tokens[i], literal)
+		}
+	}
+}
+
+func RunTokenizeTest(t *testing.T) {
+	tokens, err := Tokenize("a <= 'æø'\n\t+ f(2)", true)
+	if err != nil {
+		t.Fatalf("Tokenize failed: %s\n", err.Error())
+	}
+	expected := []Token{
+		{Type: IdentTok, Literal: "a", Line: 0, Column: 0, Start: 0},
+		{Type: WhitespaceTok, Literal: " ", Line: 0, Column: 1, Start: 1},
+		{Type: OperatorTok, Literal: "<=", Line: 0, Column: 2, Start: 2},
+		{Type: WhitespaceTok, Literal: " ", Line: 0, Column: 4, Start: 4},
+		{Type: StringTok, Literal: "'æø'", Value: "æø", Line: 0, Column: 5, Start: 5},
+		{Type: WhitespaceTok, Literal: "\n\t", Line: 0, Column: 9, Start: 11},
+		{Type: OperatorTok, Literal: "+", Line: 1, Column: 1, Start: 13},
+		{Type: WhitespaceTok, Literal: " ", Line: 1, Column: 2, Start: 14},
+		{Type: IdentTok, Literal: "f", Line: 1, Column: 3, Start: 15},
+		{Type: OperatorTok, Literal: "(", Line: 1, Column: 4, Start: 16},
+		{Type: NumberTok, Literal: "2", Value: int64(2), Line: 1, Column: 5, Start: 17},
+		{Type: OperatorTok, Literal: ")", Line: 1, Column: 6, Start: 18},
+		{Type: EoFTok, Line: 1, Column: 7, Start: 19},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Unexpected tokens: %v\n", tokens)
+	}
+	for i, tok := range tokens {
+		if tok != expected[i] {
+			t.Errorf("Unexpected token %d: %+v, expected %+v\n", i, tok, expected[i])
+		}
+	}
+	if tokens, _ := Tokenize("a <= 'æø'\n\t+ f(2)", false); len(tokens) != 9 {
+		t.Errorf("Unexpected tokens without whitespace: %v\n", tokens)
+	}
+	if IdentTok.String() != "identifier" || EoFTok.String() != "end of input" || TokenType(42).String() != "TokenType(42)" {
+		t.Errorf("Unexpected token type names\n")
+	}
+}
+
+func RunTokenizeErrorTest(t *testing.T) {
+	tokens, err := Tokenize("a +\n 'b", false)
+	se := &SyntaxError{}
+	if !errors.As(err, &se) || len(tokens) != 2 {
+		t.Fatalf("Expected syntax error: %v %v\n", tokens, err)
+	}
+	if se.Line != 2 || se.Column != 2 || se.Msg != "unterminated quoted string" || se.Token.Type != ErrorTok {
+		t.Errorf("Unexpected syntax error: %+v\n", se)
+	}
+}
+
+func RunLexerStringTest(t *testing.T, input string, value string) {
+	tokens, err := Tokenize(input, false)
+	if err != nil || len(tokens) != 2 || tokens[0].Type != StringTok || tokens[0].Value != value || tokens[0].Literal != input {
+		t.Errorf("Unexpected string token: %s. Got %v %v, expected %q\n", input, tokens, err, value)
+	}
+}
+
+func RunLexerEscapeTest(t *testing.T) {
+	RunLexerStringTest(t, `'a\nb'`, "a\nb")
+	RunLexerStringTest(t, `"\t\r\a\b\f\v"`, "\t\r\a\b\f\v")
+	RunLexerStringTest(t, `'\x41\101\u00e9\U0001F600'`, "AAé😀")
+	RunLexerStringTest(t, `'é'`, "é")
+	RunLexerStringTest(t, `'it\'s'`, "it's")
+	RunLexerStringTest(t, `"say \"hi\""`, `say "hi"`)
+	RunLexerStringTest(t, `'\"'`, `"`)
+	RunLexerStringTest(t, `"\'"`, `'`)
+	RunLexerStringTest(t, `"\\"`, `\`)
+	RunLexerStringTest(t, `'\xff'`, "\xff")
+
+	errs := map[string]string{
+		`'ab\qc'`:        "1:4: invalid escape sequence: \\q",
+		"1 +\n  'a\\x4'": "2:5: invalid escape sequence: \\x",
+		`'\u00'`:         "1:2: invalid escape sequence: \\u",
+		`'abc\`:          "1:1: unterminated quoted string",
+		`'\é'`:           "1:2: invalid escape sequence: \\é",
+		"'\\\xff":        "1:2: invalid escape sequence: \\\xff",
+		"'\\\xffab'":     "1:2: invalid escape sequence: \\\xff",
+	}
+	for input, msg := range errs {
+		_, err := Parse(input)
+		se := &SyntaxError{}
+		if !errors.As(err, &se) || fmt.Sprintf("%s: %s", se.Position, se.Msg) != msg {
+			t.Errorf("Unexpected error: %s. Got %v, expected %s\n", input, err, msg)
+		}
+	}
+
+	// Literals of string scalars are parsed to the same string
+	for _, value := range []string{"a\nb", `"quoted" \ 'single'`, "tab\t\x00\u2028é", "😀"} {
+		ex, err := Parse(NewScalarExprV(value).Literal())
+		if err != nil {
+			t.Errorf("Parse failed: %q. Error: %s\n", value, err.Error())
+			continue
+		}
+		if res, err := ex.Evaluate(NewEnvironment()); err != nil || res.Value() != value {
+			t.Errorf("Literal did not round-trip: %q. Got %v %v\n", value, res, err)
+		}
+	}
+}
+
+func RunLexerNumberTest(t *testing.T) {
+	numbers := map[string]interface{}{
+		"42":                    int64(42),
+		"0x2A":                  int64(42),
+		"0o52":                  int64(42),
+		"0b10_1010":             int64(42),
+		"0x_2A":                 int64(42),
+		"1_000_000":             int64(1000000),
+		"1.5":                   1.5,
+		".5":                    0.5,
+		"1e6":                   1e6,
+		"2.5E-3":                2.5e-3,
+		"1_000.000_5e+1_0":      1000.0005e10,
+		"9223372036854775807":   int64(9223372036854775807),
+		"9223372036854775808":   uint64(9223372036854775808),
+		"0xFFFF_FFFF_FFFF_FFFF": uint64(18446744073709551615),
+	}
+	for input, value := range numbers {
+		tokens, err := Tokenize(input, false)
+		if err != nil || len(tokens) != 2 || tokens[0].Type != NumberTok || tokens[0].Value != value || tokens[0].Literal != input {
+			t.Errorf("Unexpected number token: %s. Got %v %v, expected %v\n", input, tokens, err, value)
+		}
+	}
+
+	errs := map[string]string{
+		"18446744073709551616":        "1:1: integer 18446744073709551616 overflows 64 bits",
+		"1 + 0x1_0000_0000_0000_0000": "1:5: integer 0x1_0000_0000_0000_0000 overflows 64 bits",
+		"1e400":                       "1:1: number 1e400 is out of range",
+		"1__000":                      "1:1: bad number syntax: \"1__000\", '_' must separate digits",
+		"1_.5":                        "1:1: bad number syntax: \"1_.5\", '_' must separate digits",
+		"2e":                          "1:1: bad number syntax: \"2e\", missing exponent",
+		"0x":                          "1:1: bad number syntax: \"0x\"",
+	}
+	for input, msg := range errs {
+		_, err := Parse(input)
+		se := &SyntaxError{}
+		if !errors.As(err, &se) || fmt.Sprintf("%s: %s", se.Position, se.Msg) != msg {
+			t.Errorf("Unexpected error: %s. Got %v, expected %s\n", input, err, msg)
+		}
+	}
+
+	// A dot following an identifier or a closing bracket is a member access
+	tokens, err := Tokenize("a.b ? .5 : f().c", false)
+	if err != nil || len(tokens) != 12 || tokens[4].Value != 0.5 || tokens[9].Literal != "." {
+		t.Errorf("Unexpected tokens: %v %v\n", tokens, err)
+	}
+}
+
+func RunLexerIdentTest(t *testing.T) {
+	idents := map[string]string{
+		"_x1":             "_x1",
+		"størrelse":       "størrelse",
+		"数量2":             "数量2",
+		"@`content-type`": "content-type",
+		"@'x-id'":         "x-id",
+		`@"first name"`:   "first name",
+		`@'it\'s'`:        "it's",
+		"@`in`":           "in",
+	}
+	for input, name := range idents {
+		tokens, err := Tokenize(input, false)
+		if err != nil || len(tokens) != 2 || tokens[0].Type != IdentTok || identName(tokens[0]) != name || tokens[0].Literal != input {
+			t.Errorf("Unexpected identifier token: %s. Got %v %v, expected %s\n", input, tokens, err, name)
+		}
+	}
+
+	errs := map[string]string{
+		"a + @''":   "1:5: empty quoted identifier",
+		"@`a ${b}`": "1:1: quoted identifier cannot be interpolated",
+		"a.@'b":     "1:3: unterminated quoted string",
+		"1 + @":     "1:5: unexpected @, expected expression",
+		"@é":        "1:1: unexpected @, expected expression",
+		"1 @é":      "1:3: unexpected @, expected end of input",
+	}
+	for input, msg := range errs {
+		_, err := Parse(input)
+		se := &SyntaxError{}
+		if !errors.As(err, &se) || fmt.Sprintf("%s: %s", se.Position, se.Msg) != msg {
+			t.Errorf("Unexpected error: %s. Got %v, expected %s\n", input, err, msg)
+		}
+	}
+
+	// Operators followed by multi-byte runes
+	tokenized := map[string][]string{
+		"1 @é":     {"1", "@", "é", ""},
+		"a.é":      {"a", ".", "é", ""},
+		"2/é":      {"2", "/", "é", ""},
+		"a?.数量":    {"a", "?.", "数量", ""},
+		"x.@'é'":   {"x", ".", "@'é'", ""},
+		"{é: 1}.é": {"{", "é", ":", "1", "}", ".", "é", ""},
+	}
+	for input, expected := range tokenized {
+		tokens, err := Tokenize(input, false)
+		if err != nil || len(tokens) != len(expected) {
+			t.Errorf("Unexpected tokens: %s. Got %v %v\n", input, tokens, err)
+			continue
+		}
+		for i, literal := range expected {
+			if tokens[i].Literal != literal {
+				t.Errorf("Unexpected token %d: %s. Got %v, expected %s\n", i, input, tokens[i], literal)
+			}
+		}
+	}
+}
+
+func RunLexerRawStringTest(t *testing.T) {
+	RunLexerStringTest(t, "`a\\n'\"`", "a\\n'\"")
+	RunLexerStringTest(t, "`line 1\r\nline 2\n`", "line 1\nline 2\n")
+	tokens, err := Tokenize("`a\nbc` + x", false)
+	if err != nil || len(tokens) != 4 || tokens[2].Line != 1 || tokens[2].Column != 6 {
+		t.Errorf("Unexpected tokens following raw string: %v %v\n", tokens, err)
+	}
+	_, err = Parse("1 + `abc")
+	se := &SyntaxError{}
+	if !errors.As(err, &se) || se.Msg != "unterminated raw string" || se.Column != 5 {
+		t.Errorf("Expected unterminated raw string: %v\n", err)
+	}
+}
+
+func RunLexerCommentTest(t *testing.T) {
+	script := "// discount rule\na /* the\n total */ / 2 // half\n"
+	tokens, err := Tokenize(script, true)
+	if err != nil {
+		t.Fatalf("Tokenize failed: %s\n", err.Error())
+	}
+	comments := []Token{}
+	for _, tok := range tokens {
+		if tok.Type == CommentTok {
+			comments = append(comments, tok)
+		}
+	}
+	expected := []Token{
+		{Type: CommentTok, Literal: "// discount rule", Line: 0, Column: 0, Start: 0},
+		{Type: CommentTok, Literal: "/* the\n total */", Line: 1, Column: 2, Start: 19},
+		{Type: CommentTok, Literal: "// half", Line: 2, Column: 14, Start: 40},
+	}
+	if len(comments) != len(expected) {
+		t.Fatalf("Unexpected comments: %v\n", comments)
+	}
+	for i, tok := range comments {
+		if tok != expected[i] {
+			t.Errorf("Unexpected comment %d: %+v, expected %+v\n", i, tok, expected[i])
+		}
+	}
+
+	tokens, _ = Tokenize(script, false)
+	literals := []string{}
+	for _, tok := range tokens {
+		literals = append(literals, tok.Literal)
+	}
+	if fmt.Sprint(literals) != "[a / 2 ]" || tokens[1].Line != 2 || tokens[1].Column != 10 {
+		t.Errorf("Unexpected tokens without comments: %v\n", tokens)
+	}
+
+	env := NewEnvironment()
+	env.Set("a", NewScalarExprV(int64(10)))
+	if res, err := parseTest(t, env, script).Evaluate(env); err != nil || res.Value() != int64(5) {
+		t.Errorf("Unexpected result: %v %v\n", res, err)
+	}
+	_, err = Parse("1 + /* 2\n")
+	se := &SyntaxError{}
+	if !errors.As(err, &se) || se.Msg != "unterminated block comment" || se.Line != 1 || se.Column != 5 {
+		t.Errorf("Expected unterminated block comment: %v\n", err)
+	}
+	if CommentTok.String() != "comment" {
+		t.Errorf("Unexpected token type name: %s\n", CommentTok)
+	}
+}
+
+func RunLexerNoGoroutineTest(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if _, err := Parse("f(1 2) + [1, 2, 3, 4, 5]"); err == nil {
+			t.Fatalf("Expected syntax error\n")
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Parsing should not leave goroutines, before: %d, after: %d\n", before, after)
+	}
+}
+
+func BenchmarkLexer(b *testing.B) {
+	rule := "order.total > 1000 && customer.country in ['NO', 'SE', 'DK'] ? discount(order, 10) : 0"
+	b.SetBytes(int64(len(rule)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := newLexer(false, rule)
+		for _, fini := l.NextToken(); !fini; _, fini = l.NextToken() {
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package expr
 
 import (
-	"errors"
-	"fmt"
 	"strings"
 )
 
@@ -13,14 +11,20 @@ import (
 //	condExpr	::=		orExpr['?' expr ':' expr]
 //	orExpr		::=		andExpr['||' orExpr]
 //	andExpr		::=		cmpExpr['&&' andExpr]
-//	cmpExpr		::=		concatExpr['==', concatExpr]
-//	concatExpr	::=		atom ('+' concatExpr)*
-//	atom		::=		(text | symbol | subexpr)
+//	cmpExpr		::=		addExpr[('==' | '!=' | '>=' | '>' | '<=' | '<') addExpr]
+//	addExpr		::=		mulExpr(('+' | '-') mulExpr)*
+//	mulExpr		::=		unaryExpr(('*' | '/' | '%') unaryExpr)*
+//	unaryExpr	::=		('!' | '-') unaryExpr | powExpr
+//	powExpr		::=		postfix['**' unaryExpr]
+//	postfix		::=		atom('[' expr ']' | '[' [expr] ':' [expr] ']' | '.' ident | funcall)*
+//	atom		::=		(text | symbol | subexpr | arrayexpr | mapexpr)
 //	symbol		::=		ident[funcall]['.' symbol]
 //	funcall		::=		'(' [arglist] ')'
 //	arglist		::=		expr(',' expr) *
 //	subexpr		::=		'(' expr ')'
 //	arrayexpr	::=		'[' arglist ']'
+//	mapexpr		::=		'{' [key ':' expr(',' key ':' expr) *] '}'
+//	key			::=		(ident | text)
 type Parser struct {
 }
 
@@ -35,10 +39,146 @@ func Parse(input string) (Expression, error) {
 	return p.Parse(input)
 }
 
+// ParseAll creates an parser and parses the input string reporting all syntax errors, see Parser.ParseAll
+func ParseAll(input string) (Expression, []*SyntaxError) {
+	p := newParser()
+	return p.ParseAll(input)
+}
+
 // Parse parses the expressing from a string format
+// A single expression is returned as is, statements separated by ';' are returned as the body of a ScriptExpr.
+// Invalid input, including input following a complete expression, is reported as a *SyntaxError
 func (p Parser) Parse(input string) (Expression, error) {
 	l := newLexer(false, input)
-	return parseExpr(l)
+	ex, err := parseScript(l)
+	if err != nil {
+		return ex, err
+	}
+	return script(l, ex), nil
+}
+
+// ParseAll parses the expression like Parse, but does not stop at the first syntax error.
+// The parser recovers from an error by skipping the input up to the next ',', ')', ']', '}' or ';'
+// and all errors are returned in the order of the input.
+// The parsed expression is only returned when there are no errors
+func (p Parser) ParseAll(input string) (Expression, []*SyntaxError) {
+	l := newLexer(false, input)
+	l.recovering = true
+	ex, err := parseScript(l)
+	if err != nil {
+		// Errors that are not syntax errors are not recovered
+		return nil, append(l.diagnostics, &SyntaxError{Msg: err.Error()})
+	}
+	if len(l.diagnostics) > 0 {
+		return nil, l.diagnostics
+	}
+	return script(l, ex), nil
+}
+
+// script returns the parsed expression, wrapped in a ScriptExpr when it is a sequence of statements
+func script(lex *lexer, ex Expression) Expression {
+	switch ex.(type) {
+	case *SequenceExpr, *LetExpr:
+		return lex.markBetween(NewScriptExpr(lex.input, ex), ex, ex)
+	}
+	return ex
+}
+
+// parseScript parses the whole input, where an empty input evaluates to null.
+// Input following a complete expression is a syntax error
+func parseScript(lex *lexer) (Expression, error) {
+	t, _ := lex.NextToken()
+	if t.Type == EoFTok {
+		return NewScalarExpr("", nil), nil
+	}
+	lex.PushBack(t)
+	ex, err := parseSequence(lex)
+	for {
+		if err != nil && !lex.recoverFrom(err) {
+			return ex, err
+		}
+		t, _ = lex.NextToken()
+		if t.Type == EoFTok {
+			return ex, nil
+		}
+		if isOperator(t, ";") {
+			// The statements following a statement with errors
+			_, err = parseSequence(lex)
+			continue
+		}
+		err = unexpected(lex, t, "end of input")
+		if _, ok := lex.report(err); !ok {
+			return ex, err
+		}
+		// Look for errors in the remaining input, unless it is stopped again
+		t, _ = lex.NextToken()
+		lex.PushBack(t)
+		if syncToken(t) {
+			err = nil
+			continue
+		}
+		_, err = parseSequence(lex)
+	}
+}
+
+// parseSequence parses expressions separated by ';', where the expressions may be preceded by bindings on the
+// form let name = expr;. A single expression is returned as is, and a trailing ';' is allowed
+func parseSequence(lex *lexer) (Expression, error) {
+	var exprs []Expression
+	for {
+		var ex Expression
+		var err error
+		t, _ := lex.NextToken()
+		name, _ := lex.NextToken()
+		if t.Type == IdentTok && t.Literal == "let" && name.Type == IdentTok {
+			ex, err = parseLet(lex, t, name)
+		} else {
+			lex.PushBack(name)
+			lex.PushBack(t)
+			ex, err = parseExpr(lex)
+		}
+		if err != nil {
+			if !lex.recoverFrom(err) {
+				return ex, err
+			}
+			ex = NewScalarExpr("", nil)
+		}
+		exprs = append(exprs, ex)
+		t, _ = lex.NextToken()
+		if !isOperator(t, ";") {
+			lex.PushBack(t)
+			break
+		}
+		t, _ = lex.NextToken()
+		lex.PushBack(t)
+		if t.Type == EoFTok || isOperator(t, ")") {
+			break
+		}
+	}
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return lex.markBetween(NewSequenceExpr(exprs...), exprs[0], exprs[len(exprs)-1]), nil
+}
+
+// parseLet parses the binding following let and the name, on the form let name = expr; sequence.
+// The binding is visible in the rest of the sequence
+func parseLet(lex *lexer, let Token, name Token) (Expression, error) {
+	if _, err := expect(lex, OperatorTok, "="); err != nil {
+		return nil, err
+	}
+	value, err := parseExpr(lex)
+	if err != nil {
+		return value, err
+	}
+	if _, err := expect(lex, OperatorTok, ";"); err != nil {
+		return value, err
+	}
+	body, err := parseSequence(lex)
+	if err != nil {
+		return body, err
+	}
+	return lex.mark(NewLetExpr(identName(name), value, body), lex.position(let), spanOf(body).End), nil
 }
 
 // parseExpr parses the lexer tokens
@@ -47,7 +187,7 @@ func parseExpr(lex *lexer) (Expression, error) {
 }
 
 func parseCond(lex *lexer) (Expression, error) {
-	expr, err := parseOr(lex)
+	expr, err := parseCoalesce(lex)
 	if err != nil {
 		return expr, err
 	}
@@ -70,7 +210,7 @@ func parseCond(lex *lexer) (Expression, error) {
 		if err != nil {
 			return expr, err
 		}
-		expr = &cond
+		expr = lex.markBetween(&cond, cond.condition, cond.right)
 
 	} else {
 		lex.PushBack(t)
@@ -78,6 +218,26 @@ func parseCond(lex *lexer) (Expression, error) {
 	return expr, nil
 }
 
+// parseCoalesce parses the null-coalescing operator '??'. Left associative.
+func parseCoalesce(lex *lexer) (Expression, error) {
+	left, err := parseOr(lex)
+	if err != nil {
+		return left, err
+	}
+	for {
+		t, _ := lex.NextToken()
+		if !isOperator(t, "??") {
+			lex.PushBack(t)
+			return left, nil
+		}
+		right, err := parseOr(lex)
+		if err != nil {
+			return right, err
+		}
+		left = lex.markBetween(NewCoalesceExpr(left, right), left, right)
+	}
+}
+
 // parseOr. || Operator.
 func parseOr(lex *lexer) (Expression, error) {
 	expr, err := parseAnd(lex)
@@ -94,7 +254,7 @@ func parseOr(lex *lexer) (Expression, error) {
 		if err != nil {
 			return r, err
 		}
-		return NewOrExpr(expr, r), nil
+		return lex.markBetween(NewOrExpr(expr, r), expr, r), nil
 	}
 	lex.PushBack(t)
 	return expr, nil
@@ -116,7 +276,7 @@ func parseAnd(lex *lexer) (Expression, error) {
 		if err != nil {
 			return r, err
 		}
-		return NewAndExpr(expr, r), nil
+		return lex.markBetween(NewAndExpr(expr, r), expr, r), nil
 	}
 	lex.PushBack(t)
 	return expr, nil
@@ -124,7 +284,7 @@ func parseAnd(lex *lexer) (Expression, error) {
 
 func compareOp(operand string) bool {
 	switch operand {
-	case "==", "!=", ">=", "<=":
+	case "==", "!=", ">=", ">", "<=", "<":
 		return true
 	default:
 		return false
@@ -133,7 +293,7 @@ func compareOp(operand string) bool {
 
 // parseCmp parses the compare expression
 func parseCmp(lex *lexer) (Expression, error) {
-	left, err := parseConcat(lex)
+	left, err := parseAdd(lex)
 	if err != nil {
 		return left, err
 	}
@@ -143,35 +303,99 @@ func parseCmp(lex *lexer) (Expression, error) {
 	}
 	if t.Type == OperatorTok {
 		if compareOp(t.Literal) {
-			right, err := parseConcat(lex)
+			right, err := parseAdd(lex)
 			if err != nil {
 				return right, err
 			}
-			return NewCompareExpr(t.Literal, left, right), nil
+			return lex.markBetween(NewCompareExpr(t.Literal, left, right), left, right), nil
 		}
 		lex.PushBack(t)
 
 	} else if t.Type == IdentTok && t.Literal == "like" {
-		right, err := parseConcat(lex)
+		right, err := parseAdd(lex)
 		if err != nil {
 			return left, err
 		}
-		return NewLikeExpr(left, right), nil
+		return lex.markBetween(NewLikeExpr(left, right), left, right), nil
 
 	} else if t.Type == IdentTok && t.Literal == "in" {
-		right, err := parseConcat(lex)
+		right, err := parseAdd(lex)
 		if err != nil {
 			return left, err
 		}
-		return NewInExpr(left, right), nil
+		return lex.markBetween(NewInExpr(left, right), left, right), nil
 	} else {
 		lex.PushBack(t)
 	}
 	return left, nil
 }
 
-func parseConcat(lex *lexer) (Expression, error) {
-	left, err := parseAtom(lex)
+// parseAdd parses the additive operators '+' and '-'. Left associative.
+func parseAdd(lex *lexer) (Expression, error) {
+	left, err := parseMul(lex)
+	if err != nil {
+		return left, err
+	}
+	for {
+		t, fini := lex.NextToken()
+		if fini || t.Type == EoFTok {
+			return left, nil
+		}
+		if t.Type != OperatorTok || !(t.Literal == "+" || t.Literal == "-") {
+			lex.PushBack(t)
+			return left, nil
+		}
+		right, err := parseMul(lex)
+		if err != nil {
+			return right, err
+		}
+		left = lex.markBetween(NewArithmeticExpr(t.Literal, left, right), left, right)
+	}
+}
+
+// parseMul parses the multiplicative operators '*', '/' and '%'. Left associative.
+func parseMul(lex *lexer) (Expression, error) {
+	left, err := parseUnary(lex)
+	if err != nil {
+		return left, err
+	}
+	for {
+		t, fini := lex.NextToken()
+		if fini || t.Type == EoFTok {
+			return left, nil
+		}
+		if t.Type != OperatorTok || !(t.Literal == "*" || t.Literal == "/" || t.Literal == "%") {
+			lex.PushBack(t)
+			return left, nil
+		}
+		right, err := parseUnary(lex)
+		if err != nil {
+			return right, err
+		}
+		left = lex.markBetween(NewArithmeticExpr(t.Literal, left, right), left, right)
+	}
+}
+
+// parseUnary parses the prefix operators '!' and '-'
+func parseUnary(lex *lexer) (Expression, error) {
+	t, _ := lex.NextToken()
+	if t.Type == OperatorTok && (t.Literal == "!" || t.Literal == "-") {
+		operand, err := parseUnary(lex)
+		if err != nil {
+			return operand, err
+		}
+		if t.Literal == "!" {
+			return lex.mark(NewNotExpr(operand), lex.position(t), spanOf(operand).End), nil
+		}
+		return lex.mark(NewNegateExpr(operand), lex.position(t), spanOf(operand).End), nil
+	}
+	lex.PushBack(t)
+	return parsePow(lex)
+}
+
+// parsePow parses the power operator '**'. Right associative and binds tighter than a unary operator on its left.
+func parsePow(lex *lexer) (Expression, error) {
+	left, err := parsePostfix(lex)
 	if err != nil {
 		return left, err
 	}
@@ -179,34 +403,163 @@ func parseConcat(lex *lexer) (Expression, error) {
 	if fini || t.Type == EoFTok {
 		return left, nil
 	}
-	if t.Type == OperatorTok && t.Literal == "+" {
-
-		right, err := parseConcat(lex)
+	if t.Type == OperatorTok && t.Literal == "**" {
+		right, err := parseUnary(lex)
 		if err != nil {
 			return right, err
 		}
-		return NewConcatExpr(left, right), nil
+		return lex.markBetween(NewArithmeticExpr(t.Literal, left, right), left, right), nil
 	}
 	lex.PushBack(t)
 	return left, nil
 }
 
-func parseAtom(lex *lexer) (Expression, error) {
-	t, fini := lex.NextToken()
-	if fini || t.Type == EoFTok {
-		return NewScalarExpr("", nil), nil
+// parsePostfix parses index and member access following an atom, on the form atom[expr] and atom.ident
+func parsePostfix(lex *lexer) (Expression, error) {
+	expr, err := parseAtom(lex)
+	if err != nil {
+		return expr, err
+	}
+	return parseChain(lex, expr)
+}
+
+// parseChain parses the index, slice, member, call and safe navigation operators following expr.
+// A call of a name following a scoped call, like a.b(x).c(y), is a scoped call with the first call as scope
+func parseChain(lex *lexer, expr Expression) (Expression, error) {
+	var err error
+	for {
+		t, fini := lex.NextToken()
+		if fini || t.Type == EoFTok {
+			return expr, nil
+		}
+		if t.Type == OperatorTok && t.Literal == "[" {
+			expr, err = parseIndex(lex, expr)
+			if err != nil {
+				return expr, err
+			}
+		} else if t.Type == OperatorTok && t.Literal == "(" {
+			f := NewFuncCallExpr()
+			f.SetFunc(expr)
+			closing, err := parseArgs(lex, f)
+			if err != nil {
+				return f, err
+			}
+			expr = lex.mark(f, spanOf(expr).Start, lex.end(closing))
+		} else if t.Type == OperatorTok && t.Literal == "." {
+			t, err := expect(lex, IdentTok, "")
+			if err != nil {
+				return expr, err
+			}
+			next, _ := lex.NextToken()
+			if scope, ok := expr.(*ScopedFuncCallExpr); ok && isOperator(next, "(") {
+				f, err := NewScopedFuncCallExpr(identName(t), scope)
+				if err != nil {
+					return f, err
+				}
+				closing, err := parseArgs(lex, f)
+				if err != nil {
+					return f, err
+				}
+				expr = lex.mark(f, spanOf(expr).Start, lex.end(closing))
+				continue
+			}
+			lex.PushBack(next)
+			expr = lex.mark(NewMemberExpr(expr, identName(t)), spanOf(expr).Start, lex.end(t))
+		} else if t.Type == OperatorTok && t.Literal == "?." {
+			return parseSafeNav(lex, expr)
+		} else {
+			lex.PushBack(t)
+			return expr, nil
+		}
+	}
+}
+
+// parseSafeNav parses the chain following '?.' on target, like target?.name.name(args)[0].
+// The chain is evaluated as if written with '.', but evaluates to null when target is null
+func parseSafeNav(lex *lexer, target Expression) (Expression, error) {
+	t, err := expect(lex, IdentTok, "")
+	if err != nil {
+		return target, err
+	}
+	var access Expression
+	if scope, ok := target.(*SymbolExpr); ok {
+		// Symbols keep the lookup of scoped symbols and functions, like a?.b.c(x)
+		access, err = parseScopedIdent(lex, t, scope)
+		if err != nil {
+			return access, err
+		}
+	} else {
+		access = lex.mark(NewMemberExpr(target, identName(t)), spanOf(target).Start, lex.end(t))
+	}
+	access, err = parseChain(lex, access)
+	if err != nil {
+		return access, err
 	}
+	return lex.markBetween(NewSafeNavExpr(target, access), target, access), nil
+}
+
+// parseIndex parses the index or slice following '[', on the form [expr] or [[expr]:[expr]]
+func parseIndex(lex *lexer, target Expression) (Expression, error) {
+	var from, to Expression
+	var err error
+	t, _ := lex.NextToken()
+	if t.Type == OperatorTok && t.Literal == "]" {
+		return target, lex.errorAt(t, "missing index")
+	}
+	lex.PushBack(t)
+	if t.Type != OperatorTok || t.Literal != ":" {
+		from, err = parseExpr(lex)
+		if err != nil {
+			return from, err
+		}
+	}
+	t, _ = lex.NextToken()
+	if t.Type == OperatorTok && t.Literal == "]" {
+		return lex.mark(NewIndexExpr(target, from), spanOf(target).Start, lex.end(t)), nil
+	}
+	if t.Type != OperatorTok || t.Literal != ":" {
+		return target, unexpected(lex, t, "]")
+	}
+	t, _ = lex.NextToken()
+	if t.Type != OperatorTok || t.Literal != "]" {
+		lex.PushBack(t)
+		to, err = parseExpr(lex)
+		if err != nil {
+			return to, err
+		}
+		if t, err = expect(lex, OperatorTok, "]"); err != nil {
+			return target, err
+		}
+	}
+	return lex.mark(NewSliceExpr(target, from, to), spanOf(target).Start, lex.end(t)), nil
+}
+
+func parseAtom(lex *lexer) (Expression, error) {
+	t, _ := lex.NextToken()
 	switch t.Type {
 	case NumberTok, StringTok:
-		return NewScalarExpr(t.Literal, t.Value), nil
+		return lex.markToken(NewScalarExpr(t.Literal, t.Value), t), nil
+	case TemplateTok:
+		return parseTemplate(lex, t)
 	case IdentTok:
+		arrow, _ := lex.NextToken()
+		if isOperator(arrow, "=>") {
+			return parseLambda(lex, t, []Token{t})
+		}
+		lex.PushBack(arrow)
 		return parseIdent(lex, t)
 	case OperatorTok:
 		switch t.Literal {
 		case "(":
+			if params, ok := parseParams(lex); ok {
+				return parseLambda(lex, t, params)
+			}
 			sub, err := parseSubExpr(lex)
 			if err != nil {
-				return sub, err
+				if !lex.recoverFrom(err) {
+					return sub, err
+				}
+				sub = NewScalarExpr("", nil)
 			}
 			_, err = expect(lex, OperatorTok, ")")
 			if err != nil {
@@ -214,51 +567,184 @@ func parseAtom(lex *lexer) (Expression, error) {
 			}
 			return sub, nil
 		case "[":
-			return parseArrayExpr(lex)
-		//case "{":
-		//    expr = parseStruct(lex);
-		//    break;
-		default:
-			return nil, fmt.Errorf("unexpected operator when expecting expression term: %s", t.Literal)
+			return parseArrayExpr(lex, t)
+		case "{":
+			return parseMapExpr(lex, t)
 		}
-	default:
-		return nil, fmt.Errorf("unexpected token type when expecting expression term: %v '%s'", t.Type, t.Literal)
 	}
+	return nil, unexpected(lex, t, "expression")
 }
 
-// parseSubExpr parses list expression in format (expr)
+// parseParams parses the parameters of a lambda following '(', on the form (a, b) =>.
+// Returns false with the tokens pushed back if the tokens are not the parameters of a lambda
+func parseParams(lex *lexer) ([]Token, bool) {
+	var read, params []Token
+	next := func() Token {
+		t, _ := lex.NextToken()
+		read = append(read, t)
+		return t
+	}
+	t := next()
+	for t.Type == IdentTok {
+		params = append(params, t)
+		if t = next(); !isOperator(t, ",") {
+			break
+		}
+		t = next()
+	}
+	if isOperator(t, ")") && isOperator(next(), "=>") {
+		return params, true
+	}
+	for i := len(read) - 1; i >= 0; i-- {
+		lex.PushBack(read[i])
+	}
+	return nil, false
+}
+
+// parseLambda parses the body of a lambda following '=>', where first is the first token of the lambda
+func parseLambda(lex *lexer, first Token, params []Token) (Expression, error) {
+	names := make([]string, 0, len(params))
+	for _, p := range params {
+		name := identName(p)
+		for _, n := range names {
+			if n == name {
+				return nil, lex.errorAt(p, "duplicate parameter %s", name)
+			}
+		}
+		names = append(names, name)
+	}
+	body, err := parseExpr(lex)
+	if err != nil {
+		return body, err
+	}
+	return lex.mark(NewLambdaExpr(names, body), lex.position(first), spanOf(body).End), nil
+}
+
+// parseTemplate parses the text and embedded expressions of the template token, on the form `text ${expr} text`
+func parseTemplate(lex *lexer, t Token) (Expression, error) {
+	parts := make([]Expression, 0)
+	for _, p := range t.Value.([]templatePart) {
+		if p.tokens == nil {
+			if text := p.value(); text != "" {
+				parts = append(parts, lex.mark(NewScalarExprV(text), lex.positionIn(t, p.start), lex.positionIn(t, p.end)))
+			}
+			continue
+		}
+		// The embedded expression is parsed from its tokens, ending with }
+		sub := &lexer{input: lex.input, tokens: p.tokens, recovering: lex.recovering, diagnostics: lex.diagnostics}
+		ex, err := parseExpr(sub)
+		if err == nil {
+			_, err = expect(sub, OperatorTok, "}")
+		}
+		lex.diagnostics = sub.diagnostics
+		if err != nil {
+			if _, ok := lex.report(err); !ok {
+				return ex, err
+			}
+			ex = NewScalarExpr("", nil)
+		}
+		parts = append(parts, ex)
+	}
+	return lex.markToken(NewInterpolationExpr(parts...), t), nil
+}
+
+// parseSubExpr parses the expression in format (expr), or a sequence in format (let name = expr; expr)
 func parseSubExpr(lex *lexer) (Expression, error) {
-	return parseExpr(lex)
+	return parseSequence(lex)
 }
 
-// parseArrayExpr parses list expression in format {expr,expr,....}
-func parseArrayExpr(lex *lexer) (Expression, error) {
+// parseArrayExpr parses list expression following the '[' token open, in format [expr,expr,....]
+func parseArrayExpr(lex *lexer, open Token) (Expression, error) {
 	result := NewListExpr()
-	t, fini := lex.NextToken()
-	if fini || t.Type == EoFTok {
-		return result, nil
-	}
-	for t.Type != EoFTok || fini {
-		if t.Type == OperatorTok && t.Literal == "]" {
-			return result, nil
+	closing, err := parseList(lex, "]", func() error {
+		ele, err := parseExpr(lex)
+		if err != nil {
+			return err
 		}
-		lex.PushBack(t)
-		lele, err := parseExpr(lex)
+		result.Append(ele)
+		return nil
+	})
+	return lex.mark(result, lex.position(open), lex.end(closing)), err
+}
+
+// parseMapExpr parses map expression following the '{' token open, in format {key: expr, key: expr,....}
+func parseMapExpr(lex *lexer, open Token) (Expression, error) {
+	result := NewMapExpr()
+	closing, err := parseList(lex, "}", func() error {
+		var key string
+		t, _ := lex.NextToken()
+		switch t.Type {
+		case IdentTok:
+			key = identName(t)
+		case StringTok:
+			key = t.Value.(string)
+		default:
+			return unexpected(lex, t, "map key")
+		}
+		if _, ok := result.Get(key); ok {
+			return lex.errorAt(t, "duplicate key %s", key)
+		}
+		if _, err := expect(lex, OperatorTok, ":"); err != nil {
+			return err
+		}
+		val, err := parseExpr(lex)
 		if err != nil {
-			return lele, err
+			return err
 		}
-		result.Append(lele)
-		t, fini = lex.NextToken()
-		if !fini && t.Type == OperatorTok && t.Literal == "," {
-			t, fini = lex.NextToken()
+		result.Set(key, val)
+		return nil
+	})
+	return lex.mark(result, lex.position(open), lex.end(closing)), err
+}
+
+// parseArgs parses the arguments of the function call following '(', on the form [arglist] ')'.
+// Returns the closing token
+func parseArgs(lex *lexer, f interface{ AddArg(Expression) }) (Token, error) {
+	return parseList(lex, ")", func() error {
+		a, err := parseExpr(lex)
+		if err != nil {
+			return err
+		}
+		f.AddArg(a)
+		return nil
+	})
+}
+
+// parseList parses the elements of a list, a map or an argument list up to the closing operator,
+// on the form [element(',' element)*[',']] closing.
+// A syntax error in an element is recorded and the parsing continues with the next element when the parser recovers from errors.
+// Returns the closing token
+func parseList(lex *lexer, closing string, element func() error) (Token, error) {
+	t, _ := lex.NextToken()
+	for !isOperator(t, closing) {
+		if t.Type == EoFTok {
+			return t, unexpected(lex, t, closing)
+		}
+		lex.PushBack(t)
+		if err := element(); err != nil && !lex.recoverFrom(err) {
+			return t, err
+		}
+		t, _ = lex.NextToken()
+		if !isOperator(t, ",") && !isOperator(t, closing) {
+			err := unexpected(lex, t, ", or "+closing)
+			if !lex.recoverFrom(err) {
+				return t, err
+			}
+			t, _ = lex.NextToken()
+		}
+		if isOperator(t, ",") {
+			t, _ = lex.NextToken()
+		} else if !isOperator(t, closing) {
+			return t, unexpected(lex, t, closing)
 		}
 	}
-	return result, errors.New("list is un-terminated")
+	return t, nil
 }
 
 func parseScopedIdent(lex *lexer, t Token, scope *SymbolExpr) (Expression, error) {
-	ident := t.Literal
-	sym := NewSymbolExprWithScope(t.Literal, scope)
+	ident := t
+	sym := NewSymbolExprWithScope(identName(t), scope)
+	lex.mark(sym, scope.Span().Start, lex.end(ident))
 	t, fini := lex.NextToken()
 	if fini || t.Type == EoFTok {
 		return sym, nil
@@ -275,48 +761,22 @@ func parseScopedIdent(lex *lexer, t Token, scope *SymbolExpr) (Expression, error
 		lex.PushBack(t)
 		return sym, nil
 	}
-	f, err := NewScopedFuncCallExpr(ident, scope)
+	f, err := NewScopedFuncCallExpr(identName(ident), scope)
 	if err != nil {
 		return f, err
 	}
-	expectArg := true
-	t, fini = lex.NextToken()
-	if fini || t.Type == EoFTok {
-		return f, nil
-	}
-	for !(t.Type == OperatorTok && t.Literal == ")") {
-		lex.PushBack(t)
-		if expectArg {
-			a, err := parseExpr(lex)
-			if err != nil {
-				return a, err
-			}
-			f.AddArg(a)
-		} else {
-			expect(lex, OperatorTok, ",")
-		}
-		t, fini = lex.NextToken()
-		if fini || t.Type == EoFTok {
-			return sym, nil
-		}
-		expectArg = !expectArg
-	}
-	t, fini = lex.NextToken()
-	if fini || t.Type == EoFTok {
-		return sym, nil
-	}
-	if t.Type == OperatorTok && t.Literal == "." {
-		t, err := expect(lex, IdentTok, "")
-		if err != nil {
-			return sym, err
-		}
-		return parseScopedIdent(lex, t, sym)
+	closing, err := parseArgs(lex, f)
+	if err != nil {
+		return f, err
 	}
-	return f, nil
+	// Access following the call, like a.b(x).c, is parsed by parseChain on the result of the call
+	return lex.mark(f, scope.Span().Start, lex.end(closing)), nil
 }
 
 func parseIdent(lex *lexer, t Token) (Expression, error) {
-	sym := NewSymbolExpr(t.Literal)
+	ident := t
+	sym := NewSymbolExpr(identName(t))
+	lex.markToken(sym, ident)
 	t, fini := lex.NextToken()
 	if fini || t.Type == EoFTok {
 		return sym, nil
@@ -333,43 +793,63 @@ func parseIdent(lex *lexer, t Token) (Expression, error) {
 		return sym, nil
 	}
 	f := NewFuncCallExpr()
-	expectArg := true
 	f.SetFunc(sym)
-	t, fini = lex.NextToken()
-	if fini || t.Type == EoFTok {
-		return f, nil
+	closing, err := parseArgs(lex, f)
+	if err != nil {
+		return f, err
 	}
-	for !(t.Type == OperatorTok && t.Literal == ")") {
-		lex.PushBack(t)
-		if expectArg {
-			a, err := parseExpr(lex)
-			if err != nil {
-				return a, err
-			}
-			f.AddArg(a)
-		} else {
-			expect(lex, OperatorTok, ",")
-		}
-		t, fini = lex.NextToken()
-		if fini || t.Type == EoFTok {
-			return sym, nil
-		}
-		expectArg = !expectArg
+	return lex.mark(f, lex.position(ident), lex.end(closing)), nil
+}
+
+// identName returns the name of the identifier token. The name of a quoted identifier like @`content-type` is its value
+func identName(t Token) string {
+	if name, ok := t.Value.(string); ok {
+		return name
 	}
-	return f, nil
+	return t.Literal
 }
 
+// expect returns the next token if it is of the token type tt, and has the literal unless literal is empty
 func expect(lex *lexer, tt TokenType, literal string) (Token, error) {
-	t, fini := lex.NextToken()
-	if fini || t.Type == EoFTok {
-		return t, nil
+	t, _ := lex.NextToken()
+	if t.Type != tt || (literal != "" && !strings.EqualFold(t.Literal, literal)) {
+		want := literal
+		if want == "" {
+			want = tt.String()
+		}
+		return t, unexpected(lex, t, want)
 	}
-	if t.Type != tt {
-		//TODO check this!! %v not correct? String representation?
-		return t, fmt.Errorf("unexpected TokenType: %v", t.Type)
+	return t, nil
+}
+
+// unexpected returns a SyntaxError for the unexpected token, where want describes the expected input.
+// Lexer errors are returned with the message of the lexer
+func unexpected(lex *lexer, t Token, want string) error {
+	switch t.Type {
+	case ErrorTok:
+		return lex.errorAt(t, "%v", t.Value)
+	case EoFTok:
+		return lex.errorAt(t, "unexpected end of input, expected %s", want)
+	}
+	return lex.errorAt(t, "unexpected %s, expected %s", t.Literal, want)
+}
+
+// isOperator reports whether the token is the operator
+func isOperator(t Token, literal string) bool {
+	return t.Type == OperatorTok && t.Literal == literal
+}
+
+// syncToken reports whether the parser recovering from a syntax error stops skipping tokens at the token
+func syncToken(t Token) bool {
+	switch {
+	case t.Type == EoFTok:
+		return true
+	case t.Type != OperatorTok:
+		return false
 	}
-	if !strings.EqualFold(t.Literal, literal) {
-		return t, fmt.Errorf("unexpected literal value: %s, (expected %s)", t.Literal, literal)
+	switch t.Literal {
+	case ",", ")", "]", "}", ";":
+		return true
 	}
-	return t, nil
+	return false
 }
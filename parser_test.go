@@ -1,12 +1,24 @@
 package expr
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
 func TestParse(t *testing.T) {
 	t.Run("BinaryBool", func(t *testing.T) { RunParseBinaryBoolTest(t) })
 	t.Run("ConcatString", func(t *testing.T) { RunParseStringTest(t) })
+	t.Run("Arithmetic", func(t *testing.T) { RunParseArithmeticTest(t) })
+	t.Run("Unary", func(t *testing.T) { RunParseUnaryTest(t) })
+	t.Run("Compare", func(t *testing.T) { RunParseCompareTest(t) })
+	t.Run("Map", func(t *testing.T) { RunParseMapTest(t) })
+	t.Run("Index", func(t *testing.T) { RunParseIndexTest(t) })
+	t.Run("NullSafe", func(t *testing.T) { RunParseNullSafeTest(t) })
+	t.Run("Let", func(t *testing.T) { RunParseLetTest(t) })
+	t.Run("Identifiers", func(t *testing.T) { RunParseIdentTest(t) })
+	t.Run("Numbers", func(t *testing.T) { RunParseNumberTest(t) })
+	t.Run("Interpolation", func(t *testing.T) { RunParseInterpolationTest(t) })
 }
 
 func RunParseBinaryBoolTest(t *testing.T) {
@@ -39,31 +51,486 @@ func RunParseStringTest(t *testing.T) {
 	RunExprTest(t, "\"lunch\" in [\"breakfast\", \"lunch\", \"dinner\", \"supper\"]", true)
 }
 
+func RunParseArithmeticTest(t *testing.T) {
+	RunExprTest(t, "1 + 2", int64(3))
+	RunExprTest(t, "7 - 2 - 3", int64(2))
+	RunExprTest(t, "2 * 3 + 4", int64(10))
+	RunExprTest(t, "2 + 3 * 4", int64(14))
+	RunExprTest(t, "(2 + 3) * 4", int64(20))
+	RunExprTest(t, "7 / 2", int64(3))
+	RunExprTest(t, "7 % 4", int64(3))
+	RunExprTest(t, "7.0 / 2", 3.5)
+	RunExprTest(t, "1.5 + 1", 2.5)
+	RunExprTest(t, "2 ** 10", int64(1024))
+	RunExprTest(t, "2 ** 3 ** 2", int64(512))
+	RunExprTest(t, "2 * 3 ** 2", int64(18))
+	RunExprTest(t, "9.0 ** 0.5", 3.0)
+	//Compare with arithmetic operands
+	RunExprTest(t, "5 * 20 >= 100", true)
+	RunExprTest(t, "10 - 1 == 3 * 3", true)
+	//Plus is still concatenating strings
+	RunExprTest(t, "\"total: \" + 1 + 2", "total: 12")
+	RunExprTest(t, "1 + 2 + \" items\"", "3 items")
+	//Errors
+	RunExprErrorTest(t, "1 / 0")
+	RunExprErrorTest(t, "1 % 0")
+	RunExprErrorTest(t, "\"a\" * 2")
+	//Integer overflow
+	RunExprTest(t, "9223372036854775806 + 1", int64(9223372036854775807))
+	RunExprTest(t, "2 ** 62", int64(4611686018427387904))
+	RunExprTest(t, "(-2) ** 63", int64(-9223372036854775808))
+	RunExprTest(t, "1 ** 9223372036854775807", int64(1))
+	RunExprErrorTest(t, "-9223372036854775807 - 2")
+	RunExprErrorTest(t, "-3037000500 * 3037000500")
+	RunExprErrorTest(t, "2 ** 64")
+	RunExprErrorTest(t, "10 ** 20")
+	//Integer results too large for an int64 are uint64, like integer literals
+	RunExprTest(t, "9223372036854775807 + 1", uint64(9223372036854775808))
+	RunExprTest(t, "(-9223372036854775807 - 1) / -1", uint64(9223372036854775808))
+	RunExprTest(t, "2 ** 63", uint64(9223372036854775808))
+	RunExprTest(t, "10 ** 19", uint64(10000000000000000000))
+	RunExprTest(t, "18446744073709551615 - 1", uint64(18446744073709551614))
+	RunExprTest(t, "18446744073709551615 - 18446744073709551614", int64(1))
+	RunExprTest(t, "9223372036854775808 - 9223372036854775809", int64(-1))
+	RunExprTest(t, "-1 + 18446744073709551615", uint64(18446744073709551614))
+	RunExprTest(t, "18446744073709551615 / 5", int64(3689348814741910323))
+	RunExprTest(t, "18446744073709551615 % 10", int64(5))
+	RunExprTest(t, "9223372036854775808 * -1", int64(-9223372036854775808))
+	RunExprTest(t, "18446744073709551615 ** 0", int64(1))
+	RunExprTest(t, "18446744073709551615 + 0.5", 18446744073709551615.5)
+	RunExprTest(t, "-(-9223372036854775807 - 1)", uint64(9223372036854775808))
+	RunExprErrorTest(t, "18446744073709551615 + 1")
+	RunExprErrorTest(t, "18446744073709551615 * 2")
+	RunExprErrorTest(t, "18446744073709551615 % 0")
+	RunExprErrorTest(t, "-18446744073709551615")
+	RunExprErrorTest(t, "0 - 18446744073709551615")
+}
+
+func RunParseUnaryTest(t *testing.T) {
+	RunExprTest(t, "!true", false)
+	RunExprTest(t, "!false", true)
+	RunExprTest(t, "!!true", true)
+	RunExprTest(t, "!null", true)
+	RunExprTest(t, "!(1 == 2)", true)
+	RunExprTest(t, "!false && true", true)
+	RunExprTest(t, "!true || true", true)
+	RunExprTest(t, "-5", int64(-5))
+	RunExprTest(t, "-1.5", -1.5)
+	RunExprTest(t, "- -3", int64(3))
+	RunExprTest(t, "3 - -2", int64(5))
+	RunExprTest(t, "-5 + 3", int64(-2))
+	RunExprTest(t, "-2 ** 2", int64(-4))
+	RunExprTest(t, "2 ** -1", 0.5)
+	RunExprTest(t, "-(2 + 3) * 2", int64(-10))
+	RunExprTest(t, "-1 in [-1, 2]", true)
+	RunExprTest(t, "!false ? -1 : 1", int64(-1))
+	RunExprErrorTest(t, "-\"a\"")
+}
+
+func RunParseCompareTest(t *testing.T) {
+	RunExprTest(t, "1 < 2", true)
+	RunExprTest(t, "2 < 1", false)
+	RunExprTest(t, "2 > 1", true)
+	RunExprTest(t, "1 > 2", false)
+	RunExprTest(t, "1 > 1", false)
+	RunExprTest(t, "1 >= 1", true)
+	RunExprTest(t, "1 <= 1", true)
+	RunExprTest(t, "1.5 < 2.5", true)
+	RunExprTest(t, "31 > 30 && 29 < 30", true)
+	RunExprTest(t, "2 * 3 > 5 ? 'big' : 'small'", "big")
+	RunExprTest(t, "1<2", true)
+}
+
+func RunParseMapTest(t *testing.T) {
+	RunExprTest(t, "{name: 'x', tags: ['a','b']}.name", "x")
+	RunExprTest(t, "{name: 'x', tags: ['a','b']}['name']", "x")
+	RunExprTest(t, "{'content-type': 'json'}['content-type']", "json")
+	RunExprTest(t, "{a: {b: 1 + 1}}.a.b", int64(2))
+	RunExprTest(t, "{a: {b: 2}}['a']['b'] * 2", int64(4))
+	RunExprTest(t, "{a: 1, }.a", int64(1))
+	RunExprTest(t, "!{a: 1}.b", true)
+	RunExprTest(t, "'b' in {tags: ['a','b']}.tags", true)
+	RunExprErrorTest(t, "{a: 1}[0]")
+	RunExprErrorTest(t, "'text'.a")
+	RunSyntaxErrorPositionTest(t, "{a: 1, a: 2}", 1, 8, "duplicate key a")
+	RunSyntaxErrorPositionTest(t, "{'a': 1,\n @`a`: 2}", 2, 2, "duplicate key a")
+
+	env := NewEnvironment()
+	order := NewMapExpr()
+	order.Set("total", NewScalarExprV(int64(120)))
+	order.Set("customer", NewMapExpr())
+	env.Set("order", order)
+	env.Set("order.id", NewScalarExprV("A-1"))
+	RunExprEnvTest(t, env, "order.total > 100", true)
+	RunExprEnvTest(t, env, "order['total'] > 100", true)
+	RunExprEnvTest(t, env, "order.id", "A-1")
+	RunExprEnvTest(t, env, "order.customer.name", nil)
+	RunExprEnvTest(t, env, "missing.name", nil)
+}
+
+func RunParseIndexTest(t *testing.T) {
+	RunExprTest(t, "[1, 2, 3][0]", int64(1))
+	RunExprTest(t, "[1, 2, 3][-1]", int64(3))
+	RunExprTest(t, "[1, 2, 3][1 + 1]", int64(3))
+	RunExprTest(t, "[[1, 2], [3, 4]][1][0]", int64(3))
+	RunExprTest(t, "[1, 2, 3, 4][1:3][0]", int64(2))
+	RunExprTest(t, "[1, 2, 3, 4][1:3][-1]", int64(3))
+	RunExprTest(t, "[1, 2, 3, 4][:2][1]", int64(2))
+	RunExprTest(t, "[1, 2, 3, 4][-2:][0]", int64(3))
+	RunExprTest(t, "3 in [1, 2, 3, 4][2:]", true)
+	RunExprTest(t, "'hello'[0]", "h")
+	RunExprTest(t, "'hello'[-1]", "o")
+	RunExprTest(t, "'hello'[1:3]", "el")
+	RunExprTest(t, "'hello'[:]", "hello")
+	RunExprTest(t, "'héllo'[1]", "é")
+	RunExprTest(t, "'hello'[5:]", "")
+	RunExprTest(t, "{a: [1, 2]}.a[1]", int64(2))
+	RunExprErrorTest(t, "[1, 2, 3][3]")
+	RunExprErrorTest(t, "[1, 2, 3][-4]")
+	RunExprErrorTest(t, "[1, 2, 3][2:1]")
+	RunExprErrorTest(t, "[1, 2, 3][0:4]")
+	RunExprErrorTest(t, "'hello'[10]")
+	RunExprErrorTest(t, "[1, 2, 3]['a']")
+	RunExprErrorTest(t, "1[0]")
+	RunExprErrorTest(t, "[1, 2, 3][]")
+
+	env := NewEnvironment()
+	items := NewListExpr()
+	items.Append(NewScalarExprV("first"))
+	items.Append(NewScalarExprV("last"))
+	env.RegisterFunction("items", func(env *Environment, args []Expression) (Expression, error) {
+		return items, nil
+	})
+	RunExprEnvTest(t, env, "items()[-1]", "last")
+	RunExprEnvTest(t, env, "items()[0][1:]", "irst")
+	// Member access on the result of scoped functions
+	env.RegisterScopedFunction("#ScopeFunc:s.mk", NewSymbolExpr("s"), func(env *Environment, args []Expression) (Expression, error) {
+		m := NewMapExpr()
+		m.Set("k", NewScalarExprV("member"))
+		m.Set("l", items)
+		return m, nil
+	})
+	RunExprEnvTest(t, env, "s.mk().k", "member")
+	RunExprEnvTest(t, env, "s.mk()['k']", "member")
+	RunExprEnvTest(t, env, "s.mk().l[1]", "last")
+	RunExprEnvTest(t, env, "s.mk().k + '!'", "member!")
+	// Calls chain like the other postfix operators
+	env.RegisterScopedFunction("#ScopeFunc:s.mk.key", NewSymbolExprWithScope("mk", NewSymbolExpr("s")), func(env *Environment, args []Expression) (Expression, error) {
+		// The scope is the result of s.mk()
+		v, _ := args[len(args)-1].(*MapExpr).Get("k")
+		return v, nil
+	})
+	env.RegisterFunction("adder", func(env *Environment, args []Expression) (Expression, error) {
+		n := args[0].Value().(int64)
+		return NewNativeFunctionExpr("add", func(env *Environment, args []Expression) (Expression, error) {
+			return NewScalarExprV(n + args[0].Value().(int64)), nil
+		}), nil
+	})
+	RunExprEnvTest(t, env, "s.mk().key()", "member")
+	RunExprEnvTest(t, env, "s.mk().key()[0]", "m")
+	RunExprEnvTest(t, env, "adder(1)(2)", int64(3))
+	RunExprEnvTest(t, env, "adder(1)(2) + adder(10)(20)", int64(33))
+	RunExprEnvTest(t, env, "{f: adder(5)}.f(1)", int64(6))
+	RunExprEnvTest(t, env, "(x => x * 2)(21)", int64(42))
+	for _, script := range []string{"a.b(1).c(2)", "f(1)(2)", "a.b(1).c(2).d[0](3)"} {
+		if _, err := Parse(script); err != nil {
+			t.Errorf("Parse failed: %s. Error: %s\n", script, err.Error())
+		}
+	}
+}
+
 // Runs tests in a separate goroutine. Enables paralell testing.
+func RunParseLetTest(t *testing.T) {
+	RunExprTest(t, "let total = 30 * 4; total > 100 ? 'big' : 'small'", "big")
+	RunExprTest(t, "let a = 1; let b = a + 1; a + b", int64(3))
+	RunExprTest(t, "let a = 1; let a = a + 1; a", int64(2))
+	RunExprTest(t, "let a = 1; a;", int64(1))
+	RunExprTest(t, "1; 2; 3", int64(3))
+	RunExprTest(t, "let x = 2; (let x = 3; x) * x", int64(6))
+	RunExprTest(t, "let o = {a: {b: 1}}; o.a.b + (o?.c?.d ?? 5)", int64(6))
+	RunExprTest(t, "let s = 'x'; `${s}${s}`", "xx")
+	RunExprTest(t, "let double = x => x * 2; double(21)", int64(42))
+	RunExprTest(t, "let fact = n => n <= 1 ? 1 : n * fact(n - 1); fact(5)", int64(120))
+	// let is a symbol unless it is followed by a name
+	RunExprTest(t, "let", nil)
+
+	// Bindings are not set in the environment
+	env := NewEnvironment()
+	env.Set("price", NewScalarExprV(int64(25)))
+	RunExprEnvTest(t, env, "let price = price * 2; price", int64(50))
+	RunExprEnvTest(t, env, "let qty = 4; price * qty", int64(100))
+	RunExprEnvTest(t, env, "price", int64(25))
+	RunExprEnvTest(t, env, "qty", nil)
+
+	// Native functions called in the body set expressions in the environment
+	env.RegisterFunction("remember", func(env *Environment, args []Expression) (Expression, error) {
+		return args[0], env.Set("last", args[0])
+	})
+	RunExprEnvTest(t, env, "remember(1)", int64(1))
+	RunExprEnvTest(t, env, "let a = 2; remember(a)", int64(2))
+	RunExprEnvTest(t, env, "last", int64(2))
+	RunExprEnvTest(t, env, "let a = 3; (let b = a; remember(b)) + last", int64(6))
+	env.RegisterListBuiltins()
+	RunExprEnvTest(t, env, "let limit = 2; let over = x => x > limit; filter([1, 2, 3], over)[0]", int64(3))
+	RunExprEnvTest(t, env, "map([1, 2], x => (let y = x * 10; y + 1))[1]", int64(21))
+
+	errs := map[string]string{
+		"let x = 1":        "syntax error at 1:10: unexpected end of input, expected ;",
+		"let x = 1;":       "syntax error at 1:11: unexpected end of input, expected expression",
+		"let x == 1; x":    "syntax error at 1:7: unexpected ==, expected =",
+		"1; ; 2":           "syntax error at 1:4: unexpected ;, expected expression",
+		"(let x = 1; x; )": "",
+	}
+	for script, msg := range errs {
+		_, err := Parse(script)
+		if msg == "" {
+			if err != nil {
+				t.Errorf("Parse failed: %s. Error: %s\n", script, err.Error())
+			}
+			continue
+		}
+		if err == nil || err.Error() != msg {
+			t.Errorf("Unexpected error: %s. Got %v, expected %s\n", script, err, msg)
+		}
+	}
+
+	// Locked symbols cannot be shadowed
+	errs = map[string]string{
+		"let true = 0; 1 == 1":       "evaluation error at 1:1: symbol true is locked and cannot be bound",
+		"let false = 1; !(1 == 1)":   "evaluation error at 1:1: symbol false is locked and cannot be bound",
+		"let null = 3; x ?? 4":       "evaluation error at 1:1: symbol null is locked and cannot be bound",
+		"let a = 1; let null = a; a": "evaluation error at 1:12: symbol null is locked and cannot be bound",
+	}
+	for script, msg := range errs {
+		_, err := parseTest(t, env, script).Evaluate(env)
+		if err == nil || err.Error() != msg {
+			t.Errorf("Unexpected error: %s. Got %v, expected %s\n", script, err, msg)
+		}
+	}
+
+	// Only statements are returned as a ScriptExpr, a single expression is returned as is
+	types := map[string]string{
+		"1 + 2":        "*expr.ArithmeticExpr",
+		"f(1)":         "*expr.FuncCallExpr",
+		"(1; 2)":       "*expr.ScriptExpr",
+		"1; 2":         "*expr.ScriptExpr",
+		"let a = 1; a": "*expr.ScriptExpr",
+		"":             "*expr.ScalarExpr",
+	}
+	for script, typ := range types {
+		if ex, err := Parse(script); err != nil || fmt.Sprintf("%T", ex) != typ {
+			t.Errorf("Unexpected expression: %s. Got %T %v, expected %s\n", script, ex, err, typ)
+		}
+	}
+}
+
+func RunParseNullSafeTest(t *testing.T) {
+	RunExprTest(t, "null ?? 1", int64(1))
+	RunExprTest(t, "missing ?? 'default'", "default")
+	RunExprTest(t, "0 ?? 1", int64(0))
+	RunExprTest(t, "false ?? true", false)
+	RunExprTest(t, "null ?? null ?? 'c'", "c")
+	RunExprTest(t, "missing ?? 1 + 2", int64(3))
+	RunExprTest(t, "(null ?? false) ? 1 : 2", int64(2))
+	RunExprTest(t, "null ?? false || true", true)
+	RunExprTest(t, "{a: {b: 2}}?.a?.b", int64(2))
+	RunExprTest(t, "{a: null}.a?.b", nil)
+	RunExprTest(t, "{a: null}.a?.b.c[0]", nil)
+	RunExprTest(t, "{a: null}.a?.b ?? 'none'", "none")
+	RunExprTest(t, "true ?.5:1", 0.5)
+	RunExprTest(t, "true?.5:1", 0.5)
+	RunExprErrorTest(t, "{a: null}.a.b")
+	RunExprErrorTest(t, "{a: {}}?.a.b.c")
+
+	env := NewEnvironment()
+	calls := 0
+	env.RegisterFunction("order", func(env *Environment, args []Expression) (Expression, error) {
+		calls++
+		order := NewMapExpr()
+		order.Set("customer", NewMapExpr())
+		return order, nil
+	})
+	env.RegisterFunction("none", func(env *Environment, args []Expression) (Expression, error) {
+		return env.Null(), nil
+	})
+	env.RegisterScopedFunction("#ScopeFunc:user.address.format", NewSymbolExprWithScope("address", NewSymbolExpr("user")), func(env *Environment, args []Expression) (Expression, error) {
+		return NewScalarExprV("formatted " + args[len(args)-1].String()), nil
+	})
+	RunExprEnvTest(t, env, "order()?.customer?.name ?? 'anonymous'", "anonymous")
+	RunExprEnvTest(t, env, "none()?.customer.name", nil)
+	RunExprEnvTest(t, env, "user?.address?.format('x')", nil)
+	RunExprEnvTest(t, env, "user?.address.format('x') ?? 'unknown'", "unknown")
+	user := NewMapExpr()
+	user.Set("address", NewMapExpr())
+	env.Set("user", user)
+	RunExprEnvTest(t, env, "user?.address?.format('x')", "formatted x")
+	RunExprEnvTest(t, env, "user?.missing?.format('x')", nil)
+
+	// The target of ?. is evaluated once
+	calls = 0
+	RunExprEnvTest(t, env, "order()?.customer?.name", nil)
+	if calls != 1 {
+		t.Errorf("Unexpected number of calls: %d\n", calls)
+	}
+}
+
+func RunParseIdentTest(t *testing.T) {
+	RunExprTest(t, "{'content-type': 'json'}.@`content-type`", "json")
+	RunExprTest(t, "{@'x-id': 7}['x-id']", int64(7))
+	RunExprTest(t, "{größe: 3}.größe", int64(3))
+	RunExprTest(t, "{é: 1}.é", int64(1))
+	RunExprTest(t, "{é: {数量: 4}}.é.数量 / 2", int64(2))
+	RunExprTest(t, "{é: 1}?.é", int64(1))
+	env := NewEnvironment()
+	env.Set("é", NewScalarExprV(int64(4)))
+	RunExprEnvTest(t, env, "8/é", int64(2))
+	if _, diags := ParseAll("1 @é"); len(diags) != 1 {
+		t.Errorf("Unexpected diagnostics: %v\n", diags)
+	}
+
+	env = NewEnvironment()
+	headers := NewMapExpr()
+	headers.Set("content-type", NewScalarExprV("json"))
+	env.Set("headers", headers)
+	env.Set("x-id", NewScalarExprV(int64(42)))
+	env.Set("størrelse", NewScalarExprV(int64(2)))
+	RunExprEnvTest(t, env, "@'x-id' + størrelse", int64(44))
+	RunExprEnvTest(t, env, "headers.@\"content-type\" == 'json'", true)
+	RunExprEnvTest(t, env, "@`headers`.@`content-type`", "json")
+	env.RegisterFunction("to-upper", func(env *Environment, args []Expression) (Expression, error) {
+		return NewScalarExprV(strings.ToUpper(args[0].String())), nil
+	})
+	RunExprEnvTest(t, env, "@'to-upper'('a')", "A")
+
+	ex, err := Parse("@'x-id'")
+	if err != nil {
+		t.Fatalf("Parse failed: %s\n", err.Error())
+	}
+	if sym, ok := ex.(*SymbolExpr); !ok || sym.Literal() != "x-id" {
+		t.Errorf("Expected symbol: %v\n", ex)
+	}
+}
+
+func RunParseNumberTest(t *testing.T) {
+	RunExprTest(t, "1e3 + 0.5", 1000.5)
+	RunExprTest(t, "1_000 * 3", int64(3000))
+	RunExprTest(t, "true ? .5 : 1", 0.5)
+	RunExprTest(t, "-9223372036854775808", int64(-9223372036854775808))
+	RunExprTest(t, "-9223372036854775808 < 0", true)
+	RunExprTest(t, "18446744073709551615 > 9223372036854775807", true)
+	RunExprTest(t, "9223372036854775808 == 0x8000_0000_0000_0000", true)
+}
+
+func RunParseInterpolationTest(t *testing.T) {
+	RunExprTest(t, "`1 + 2 = ${1 + 2}`", "1 + 2 = 3")
+	RunExprTest(t, "`${'a'}${'b'}`", "ab")
+	RunExprTest(t, "`${ {a: 1}.a }`", "1")
+	RunExprTest(t, "`outer ${`inner ${1 * 2}`}!`", "outer inner 2!")
+	RunExprTest(t, "`${'}'}`", "}")
+	RunExprTest(t, "`$${not} $${1}`", "${not} ${1}")
+	RunExprTest(t, "`$ {1} $x`", "$ {1} $x")
+	RunExprTest(t, "`null: ${null}.`", "null: .")
+	RunExprTest(t, "`line ${1}\r\nline ${2}`", "line 1\nline 2")
+	RunExprTest(t, "`${ /* comment */ 1 }` + 1", "11")
+	RunExprErrorTest(t, "`${1 / 0}`")
+
+	env := NewEnvironment()
+	user := NewMapExpr()
+	user.Set("name", NewScalarExprV("Kari"))
+	env.Set("user", user)
+	env.Set("amount", NewScalarExprV(42.5))
+	RunExprEnvTest(t, env, "`Hello ${user.name}, you owe ${amount}`", "Hello Kari, you owe 42.5")
+
+	ex, err := Parse("`a ${x} $${y}`")
+	if err != nil || ex.Literal() != "`a ${x} $${y}`" {
+		t.Errorf("Unexpected literal: %v %v\n", ex, err)
+	}
+	errs := map[string]string{
+		"`a ${1 +}`":     "syntax error at 1:9: unexpected }, expected expression",
+		"`a ${1 2}`":     "syntax error at 1:8: unexpected 2, expected }",
+		"`a\n ${}`":      "syntax error at 2:4: unexpected }, expected expression",
+		"`a ${1 + 2":     "syntax error at 1:4: unterminated interpolation",
+		"`a ${1 + 2`":    "syntax error at 1:11: unterminated raw string",
+		"`a ${'b}`":      "syntax error at 1:6: unterminated quoted string",
+		"`a ${`b ${1`}`": "syntax error at 1:9: unterminated interpolation",
+	}
+	for script, msg := range errs {
+		if _, err := Parse(script); err == nil || err.Error() != msg {
+			t.Errorf("Unexpected error: %s. Got %v, expected %s\n", script, err, msg)
+		}
+	}
+	if _, diags := ParseAll("f(`${1 +}`, `${)}`, 1 2)"); len(diags) != 3 {
+		t.Errorf("Unexpected diagnostics: %v\n", diags)
+	}
+}
+
 func RunExprTest(t *testing.T, testString string, expectedValue interface{}) {
 	t.Run(testString, func(t *testing.T) { exprTest(t, testString, expectedValue) })
 }
 
+// Runs tests in a separate goroutine, evaluating in the provided environment.
+func RunExprEnvTest(t *testing.T, env *Environment, testString string, expectedValue interface{}) {
+	t.Run(testString, func(t *testing.T) { exprEnvTest(t, env, testString, expectedValue) })
+}
+
 func exprTest(t *testing.T, testString string, expectedValue interface{}) {
-	//Step 1: Create environment
-	env := NewEnvironment()
-	//Step 2: Get parser
+	exprEnvTest(t, NewEnvironment(), testString, expectedValue)
+}
+
+func exprEnvTest(t *testing.T, env *Environment, testString string, expectedValue interface{}) {
+	//Step 1: Get parser
 	parser := env.GetParser()
-	//Step 3: Parse the input
+	//Step 2: Parse the input
 	ex, err := parser.Parse(testString)
 	if err != nil {
 		t.Errorf("Parse failed: %v. \n\n Error: %s\n", ex, err.Error())
 		return
 	}
-	//Step 4: Evaluate the parsed text
-	exEv, errEv := ex.Evaluate(NewEnvironment())
+	//Step 3: Evaluate the parsed text
+	exEv, errEv := ex.Evaluate(env)
 	if errEv != nil {
 		t.Errorf("Eval failed:\n\n %v \n\n Evaluated to:\n %v \n Error: %s\n", ex.Literal(), exEv, errEv.Error())
 		return
 	}
-	//Step 5: Check result
+	//Step 4: Check result
 	if expectedValue != exEv.Value() {
 		t.Errorf("Compare failed:\n\n %v \n\n Evaluated to:\n\n %v \n", ex, exEv.Value())
 		return
 	}
 }
+
+// Runs tests expecting the evaluation to fail in a separate goroutine.
+func RunExprErrorTest(t *testing.T, testString string) {
+	t.Run(testString, func(t *testing.T) { exprErrorTest(t, testString) })
+}
+
+func exprErrorTest(t *testing.T, testString string) {
+	env := NewEnvironment()
+	ex, err := env.GetParser().Parse(testString)
+	if err != nil {
+		return
+	}
+	exEv, errEv := ex.Evaluate(env)
+	if errEv == nil {
+		t.Errorf("Expected error:\n\n %v \n\n Evaluated to:\n\n %v \n", ex.Literal(), exEv.Value())
+	}
+}
+
+// BenchmarkParseRules parses a thousand rules per operation, like an application loading its rules at startup
+func BenchmarkParseRules(b *testing.B) {
+	rules := make([]string, 1000)
+	size := 0
+	for i := range rules {
+		rules[i] = fmt.Sprintf("order.total > %d && customer.country in ['NO', 'SE', 'DK'] ? discount(order, %d) : -(fee(order) * 1.25)", i*10, i%10)
+		size += len(rules[i])
+	}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, rule := range rules {
+			if _, err := Parse(rule); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package expr
+
+import (
+	"testing"
+)
+
+func TestSpan(t *testing.T) {
+	t.Run("Nodes", func(t *testing.T) { RunSpanNodesTest(t) })
+	t.Run("Every", func(t *testing.T) { RunSpanEveryNodeTest(t) })
+}
+
+func RunSpanTest(t *testing.T, ex Expression, text string, source string) {
+	span := spanOf(ex)
+	if !span.IsValid() {
+		t.Errorf("Missing span: %s\n", ex.Literal())
+		return
+	}
+	if got := source[span.Start.Offset:span.End.Offset]; got != text {
+		t.Errorf("Unexpected span %s: %q, expected %q\n", span, got, text)
+	}
+}
+
+func RunSpanNodesTest(t *testing.T) {
+	source := "a.b(1, 'x') +\n  [1, 2][0] * -c.d"
+	ex, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse failed: %s\n", err.Error())
+	}
+	add := ex.(*ArithmeticExpr)
+	RunSpanTest(t, add, source, source)
+	RunSpanTest(t, add.left, "a.b(1, 'x')", source)
+	RunSpanTest(t, add.left.(*ScopedFuncCallExpr).args[1], "'x'", source)
+	mul := add.right.(*ArithmeticExpr)
+	RunSpanTest(t, mul, "[1, 2][0] * -c.d", source)
+	RunSpanTest(t, mul.left, "[1, 2][0]", source)
+	RunSpanTest(t, mul.left.(*IndexExpr).target, "[1, 2]", source)
+	RunSpanTest(t, mul.right, "-c.d", source)
+	RunSpanTest(t, mul.right.(*NegateExpr).operand, "c.d", source)
+
+	span := spanOf(mul.right)
+	if span.Start != (Position{Offset: 28, Line: 2, Column: 15}) || span.End != (Position{Offset: 32, Line: 2, Column: 19}) {
+		t.Errorf("Unexpected span: %s\n", span)
+	}
+	if spanOf(NewScalarExprV(1)).IsValid() || spanOf(NewNativeFunctionExpr("f", nil)).IsValid() {
+		t.Errorf("Expressions not created by the parser should not have a span\n")
+	}
+}
+
+// spanNodes calls fn for the expression and every expression below it
+func spanNodes(ex Expression, fn func(Expression)) {
+	fn(ex)
+	var children []Expression
+	switch e := ex.(type) {
+	case *ScriptExpr:
+		children = []Expression{e.body}
+	case *SymbolExpr:
+		if e.scope != nil {
+			children = []Expression{e.scope}
+		}
+	case *CondExpr:
+		children = []Expression{e.condition, e.left, e.right}
+	case *CoalesceExpr:
+		children = []Expression{e.left, e.right}
+	case *OrExpr:
+		children = []Expression{e.left, e.right}
+	case *AndExpr:
+		children = []Expression{e.left, e.right}
+	case *NotExpr:
+		children = []Expression{e.operand}
+	case *NegateExpr:
+		children = []Expression{e.operand}
+	case *CompareExpr:
+		children = []Expression{e.left, e.right}
+	case *ArithmeticExpr:
+		children = []Expression{e.left, e.right}
+	case *LikeExpr:
+		children = []Expression{e.left, e.right}
+	case *InExpr:
+		children = []Expression{e.left, e.right}
+	case *ListExpr:
+		children = e.exprs
+	case *MapExpr:
+		for _, k := range e.keys {
+			children = append(children, e.exprs[k])
+		}
+	case *IndexExpr:
+		children = []Expression{e.target, e.index}
+	case *SliceExpr:
+		children = []Expression{e.target, e.from, e.to}
+	case *MemberExpr:
+		children = []Expression{e.target}
+	case *SafeNavExpr:
+		children = []Expression{e.target, e.access}
+	case *InterpolationExpr:
+		children = e.parts
+	case *LambdaExpr:
+		children = []Expression{e.body}
+	case *LetExpr:
+		children = []Expression{e.value, e.body}
+	case *SequenceExpr:
+		children = e.exprs
+	case *FuncCallExpr:
+		children = append([]Expression{e.function}, e.args...)
+	case *ScopedFuncCallExpr:
+		children = append([]Expression{e.scope}, e.args...)
+	}
+	for _, c := range children {
+		if c != nil {
+			spanNodes(c, fn)
+		}
+	}
+}
+
+func RunSpanEveryNodeTest(t *testing.T) {
+	scripts := []string{
+		"a && b || !c ? [1, {x: 2 ** 3}][0:1] : f(x.y, 'a' like 'b', 1 in [1])",
+		"a.b.c(1)[1] == -2 % 3 - 4 / 5",
+		"a?.b?.c(1) ?? f()?.d[0] ?? `x ${y ?? 1}`",
+		"reduce(map(xs, x => x.a * 2), (acc, v) => acc + v, 0)",
+		"let a = 1; let f = x => (let y = x; y * a); f(a); f(2)",
+	}
+	for _, script := range scripts {
+		ex, err := Parse(script)
+		if err != nil {
+			t.Fatalf("Parse failed: %s. Error: %s\n", script, err.Error())
+		}
+		spanNodes(ex, func(ex Expression) {
+			if !spanOf(ex).IsValid() {
+				t.Errorf("Missing span: %T %s\n", ex, ex.Literal())
+			}
+		})
+	}
+}